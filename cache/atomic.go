@@ -0,0 +1,113 @@
+package cacheutils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+	intutils "github.com/sudosz/go-utils/ints"
+)
+
+// ErrNotInteger is returned by Incr and Decr when the stored value is not a decimal integer.
+var ErrNotInteger = errors.New("cacheutils: value is not an integer")
+
+// Incr adds delta to the integer stored under key and returns the new value.
+// Missing keys start at zero; the remaining TTL is kept when the cache indexes keys.
+// Optimization: Read-modify-write under a single write lock, values stored as decimal text.
+func (c *Cache) Incr(key string, delta int64) (int64, error) {
+	return c.IncrWithTTL(key, delta, 0)
+}
+
+// Decr subtracts delta from the integer stored under key and returns the new value.
+// Optimization: Same as Incr with a negated delta.
+func (c *Cache) Decr(key string, delta int64) (int64, error) {
+	return c.IncrWithTTL(key, -delta, 0)
+}
+
+// IncrWithTTL is like Incr but applies ttl when the counter is created,
+// which makes fixed-window rate counters a single call.
+// Optimization: Read-modify-write under a single write lock.
+func (c *Cache) IncrWithTTL(key string, delta int64, ttl time.Duration) (int64, error) {
+	k := bytesutils.S2b(key)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := int64(0)
+	if value, err := c.get(k); err == nil {
+		if n, err = strconv.ParseInt(bytesutils.B2s(value), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+		ttl = c.ttl(k)
+	}
+	n += delta
+	if err := c.put(k, intutils.Int64ToBytes(n), ttl); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CompareAndSwap replaces the value under key with new if the current value equals old.
+// It reports false if the key is missing or holds a different value.
+// Optimization: Comparison and write happen under a single write lock.
+func (c *Cache) CompareAndSwap(key string, old, new []byte) (bool, error) {
+	return c.compareAndSwap(bytesutils.S2b(key), old, new, 0, false)
+}
+
+// CompareAndSwapWithTTL is like CompareAndSwap but stores new with a fresh time-to-live.
+// Optimization: Comparison and write happen under a single write lock.
+func (c *Cache) CompareAndSwapWithTTL(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	return c.compareAndSwap(bytesutils.S2b(key), old, new, ttl, true)
+}
+
+// compareAndSwap swaps under the write lock, keeping the current TTL unless setTTL is true.
+func (c *Cache) compareAndSwap(key, old, new []byte, ttl time.Duration, setTTL bool) (bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	value, err := c.get(key)
+	if err != nil || bytesutils.B2s(value) != bytesutils.B2s(old) {
+		return false, nil
+	}
+	if !setTTL {
+		ttl = c.ttl(key)
+	}
+	if err := c.put(key, new, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetIfAbsent stores value under key only if the key is missing or expired,
+// with an optional time-to-live, and reports whether it was stored.
+// Optimization: Existence check and write happen under a single write lock.
+func (c *Cache) SetIfAbsent(key string, value []byte, ttl ...time.Duration) (bool, error) {
+	d := time.Duration(0)
+	if len(ttl) > 0 {
+		d = ttl[0]
+	}
+	k := bytesutils.S2b(key)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.has(k) {
+		return false, nil
+	}
+	if err := c.put(k, value, d); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete deletes key if its current value equals old and reports whether it was deleted.
+// Optimization: Comparison and deletion happen under a single write lock.
+func (c *Cache) CompareAndDelete(key string, old []byte) (bool, error) {
+	k := bytesutils.S2b(key)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	value, err := c.get(k)
+	if err != nil || bytesutils.B2s(value) != bytesutils.B2s(old) {
+		return false, nil
+	}
+	if err := c.del(k); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,211 @@
+package cacheutils
+
+import (
+	"errors"
+	"time"
+
+	"git.mills.io/prologic/bitcask"
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+// ErrTxClosed is returned when a transaction is used after it was committed or discarded.
+var ErrTxClosed = errors.New("cacheutils: transaction is closed")
+
+// txOp is a single buffered write of a transaction.
+type txOp struct {
+	key   []byte
+	value []byte
+	ttl   time.Duration
+	del   bool
+}
+
+// txUndo records the state of a key before a transaction touched it.
+type txUndo struct {
+	key   []byte
+	value []byte
+	ttl   time.Duration
+	found bool
+}
+
+// Tx buffers writes and applies them together on Commit.
+// If a write fails, already applied writes are rolled back to their previous values;
+// restored entries keep their TTL only when the cache indexes keys.
+type Tx struct {
+	c      *Cache
+	ops    []txOp
+	locked bool
+	closed bool
+}
+
+// Batch starts a buffered batch of writes that are applied atomically on Commit.
+// Optimization: Writes are only buffered until Commit, which takes the lock once.
+func (c *Cache) Batch() *Tx {
+	return &Tx{c: c}
+}
+
+// Update runs fn in a transaction holding the cache's write lock and commits its writes
+// if fn returns nil. Reads inside fn see the transaction's own pending writes.
+// Optimization: A single lock acquisition covers all reads and writes of the transaction.
+func (c *Cache) Update(fn func(tx *Tx) error) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	tx := &Tx{c: c, locked: true}
+	if err := fn(tx); err != nil {
+		tx.closed = true
+		return err
+	}
+	return tx.commit()
+}
+
+// Get returns the value for key, taking pending writes of the transaction into account.
+// Optimization: Scans pending writes backwards so the latest write wins without a map.
+func (tx *Tx) Get(key string) ([]byte, error) {
+	if tx.closed {
+		return nil, ErrTxClosed
+	}
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		if op := tx.ops[i]; bytesutils.B2s(op.key) == key {
+			if op.del {
+				return nil, bitcask.ErrKeyNotFound
+			}
+			return op.value, nil
+		}
+	}
+	if tx.locked {
+		return tx.c.get(bytesutils.S2b(key))
+	}
+	return tx.c.Get(key)
+}
+
+// Set buffers a write of value under key.
+// Optimization: Zero-copy S2b for the key.
+func (tx *Tx) Set(key string, value []byte) *Tx {
+	return tx.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL buffers a write of value under key with a time-to-live.
+// Optimization: Zero-copy S2b for the key.
+func (tx *Tx) SetWithTTL(key string, value []byte, ttl time.Duration) *Tx {
+	tx.ops = append(tx.ops, txOp{key: bytesutils.S2b(key), value: value, ttl: ttl})
+	return tx
+}
+
+// Del buffers a deletion of key.
+// Optimization: Zero-copy S2b for the key.
+func (tx *Tx) Del(key string) *Tx {
+	tx.ops = append(tx.ops, txOp{key: bytesutils.S2b(key), del: true})
+	return tx
+}
+
+// Len returns the number of buffered writes.
+// Optimization: Direct slice length.
+func (tx *Tx) Len() int {
+	return len(tx.ops)
+}
+
+// Discard drops all buffered writes.
+// Optimization: Releases the buffer for garbage collection.
+func (tx *Tx) Discard() {
+	tx.ops = nil
+	tx.closed = true
+}
+
+// Commit applies all buffered writes, rolling back on the first failure.
+// Inside Update the commit happens automatically when fn returns nil.
+// Optimization: Takes the write lock once for the whole batch.
+func (tx *Tx) Commit() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	if tx.locked {
+		return nil
+	}
+	tx.c.mux.Lock()
+	defer tx.c.mux.Unlock()
+	return tx.commit()
+}
+
+// commit applies the buffered writes; the caller holds the write lock.
+func (tx *Tx) commit() error {
+	tx.closed = true
+	c := tx.c
+	undo := make([]txUndo, 0, len(tx.ops))
+	seen := make(map[string]struct{}, len(tx.ops))
+	for _, op := range tx.ops {
+		if _, ok := seen[bytesutils.B2s(op.key)]; ok {
+			continue
+		}
+		seen[bytesutils.B2s(op.key)] = struct{}{}
+		value, err := c.get(op.key)
+		undo = append(undo, txUndo{key: op.key, value: value, ttl: c.ttl(op.key), found: err == nil})
+	}
+	for _, op := range tx.ops {
+		var err error
+		if op.del {
+			err = c.del(op.key)
+		} else {
+			err = c.put(op.key, op.value, op.ttl)
+		}
+		if err != nil {
+			tx.rollback(undo)
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores the recorded state of every touched key, best effort.
+func (tx *Tx) rollback(undo []txUndo) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		if u.found && u.ttl >= 0 {
+			tx.c.put(u.key, u.value, u.ttl)
+		} else {
+			tx.c.del(u.key)
+		}
+	}
+}
+
+// GetMany returns the values of all given keys that are present; missing or expired keys are omitted.
+// Optimization: Reads every key under a single read lock.
+func (c *Cache) GetMany(keys ...string) (map[string][]byte, error) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, err := c.get(bytesutils.S2b(key))
+		c.recordRead(err == nil)
+		switch {
+		case err == nil:
+			values[key] = value
+		case errors.Is(err, bitcask.ErrKeyNotFound), errors.Is(err, bitcask.ErrKeyExpired):
+		default:
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// SetMany stores all entries atomically, with an optional time-to-live applied to each.
+// Optimization: Applied as a single batch under one lock acquisition.
+func (c *Cache) SetMany(entries map[string][]byte, ttl ...time.Duration) error {
+	d := time.Duration(0)
+	if len(ttl) > 0 {
+		d = ttl[0]
+	}
+	tx := c.Batch()
+	for key, value := range entries {
+		tx.SetWithTTL(key, value, d)
+	}
+	return tx.Commit()
+}
+
+// DeleteMany deletes all given keys atomically.
+// Optimization: Applied as a single batch under one lock acquisition.
+func (c *Cache) DeleteMany(keys ...string) error {
+	tx := c.Batch()
+	for _, key := range keys {
+		tx.Del(key)
+	}
+	return tx.Commit()
+}
@@ -1,25 +1,96 @@
 package cacheutils
 
 import (
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"git.mills.io/prologic/bitcask"
 	bytesutils "github.com/sudosz/go-utils/bytes"
 )
 
+// ErrKeyIndexDisabled is returned by key listing operations on caches opened without WithKeyIndex.
+var ErrKeyIndexDisabled = errors.New("cacheutils: key index is disabled")
+
 // Cache represents a cache using bitcask as the underlying storage.
+// Caches must be created with New; views returned by WithOptions share its lock.
 type Cache struct {
 	DB *bitcask.Bitcask
+
+	mux           *sync.RWMutex
+	folder        string
+	prefix        []byte
+	defaultTTL    time.Duration
+	hasher        Hasher
+	keyIndex      bool
+	collisionSafe bool
+	codec         Codec
+	compressMin   int
+	aead          cipher.AEAD
+	maintenance   *Maintenance
+	dbOptions     []bitcask.Option
+	maxKeySize    uint32
+	maxValueSize  uint64
+	events        *notifier
+	stats         *counters
+	stop          func()
 }
 
-// New creates a new Cache instance with the given folder path.
+// New creates a new Cache instance with the given folder path and options.
 // Optimization: Relies on bitcask's efficiency; no additional overhead added.
-func New(folder string) (*Cache, error) {
-	db, err := bitcask.Open(folder)
+func New(folder string, opts ...Option) (*Cache, error) {
+	c := &Cache{mux: &sync.RWMutex{}, folder: folder, events: newNotifier(), stats: &counters{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	db, err := bitcask.Open(folder, c.dbOptions...)
 	if err != nil {
 		return nil, err
 	}
-	return &Cache{DB: db}, nil
+	c.DB = db
+	c.maxKeySize, c.maxValueSize = storeLimits(folder)
+	if c.maintenance != nil {
+		c.stop = c.startMaintenance(*c.maintenance)
+	}
+	return c, nil
+}
+
+// storeLimits reads the key and value size limits bitcask persisted for the store in folder;
+// zero means unknown, leaving the checks to bitcask.
+func storeLimits(folder string) (maxKeySize uint32, maxValueSize uint64) {
+	var cfg struct {
+		MaxKeySize   uint32 `json:"max_key_size"`
+		MaxValueSize uint64 `json:"max_value_size"`
+	}
+	if data, err := os.ReadFile(filepath.Join(folder, "config.json")); err == nil {
+		json.Unmarshal(data, &cfg)
+	}
+	return cfg.MaxKeySize, cfg.MaxValueSize
+}
+
+// checkSize returns bitcask's error for a record exceeding the store's size limits.
+func (c *Cache) checkSize(key, value []byte) error {
+	if c.maxKeySize > 0 && uint32(len(key)) > c.maxKeySize {
+		return bitcask.ErrKeyTooLarge
+	}
+	if c.maxValueSize > 0 && uint64(len(value)) > c.maxValueSize {
+		return bitcask.ErrValueTooLarge
+	}
+	return nil
+}
+
+// WithOptions returns a view of the cache sharing its DB with the given options applied on top.
+// Optimization: Copies only the configuration; no files are reopened.
+func (c *Cache) WithOptions(opts ...Option) *Cache {
+	v := *c
+	for _, opt := range opts {
+		opt(&v)
+	}
+	return &v
 }
 
 // Get retrieves the value for the given string key, converting it to bytes.
@@ -29,9 +100,31 @@ func (c *Cache) Get(key string) ([]byte, error) {
 }
 
 // GetBytes retrieves the value for the given byte slice key using a hash.
-// Optimization: Efficient key hashing via getKeyHash.
+// Optimization: Efficient key hashing via the configured Hasher.
 func (c *Cache) GetBytes(key []byte) ([]byte, error) {
-	return c.DB.Get(getKeyHash(key))
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	value, err := c.get(key)
+	c.recordRead(err == nil)
+	return value, err
+}
+
+// get reads and verifies the value for key; the caller holds the lock.
+func (c *Cache) get(key []byte) ([]byte, error) {
+	stored, err := c.DB.Get(c.hash(key))
+	if err != nil {
+		if errors.Is(err, bitcask.ErrKeyExpired) {
+			c.notify(EventExpire, key)
+		}
+		return nil, err
+	}
+	if c.collisionSafe {
+		var ok bool
+		if stored, ok = checkKey(key, stored); !ok {
+			return nil, bitcask.ErrKeyNotFound
+		}
+	}
+	return c.decodeValue(key, stored)
 }
 
 // Has checks if the given string key exists in the cache.
@@ -41,9 +134,20 @@ func (c *Cache) Has(key string) bool {
 }
 
 // HasBytes checks if the given byte slice key exists in the cache.
-// Optimization: Efficient key hashing via getKeyHash.
+// Optimization: Efficient key hashing; collision-safe caches also read the stored key.
 func (c *Cache) HasBytes(key []byte) bool {
-	return c.DB.Has(getKeyHash(key))
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.has(key)
+}
+
+// has reports whether key is stored; the caller holds the lock.
+func (c *Cache) has(key []byte) bool {
+	if c.collisionSafe {
+		_, err := c.get(key)
+		return err == nil
+	}
+	return c.DB.Has(c.hash(key))
 }
 
 // Set sets the value for the given string key and value.
@@ -55,7 +159,9 @@ func (c *Cache) Set(key string, value string) error {
 // SetBytesKVWithTTL sets the value for the byte slice key with a time-to-live.
 // Optimization: Direct use of bitcask’s TTL feature.
 func (c *Cache) SetBytesKVWithTTL(key []byte, value []byte, ttl time.Duration) error {
-	return c.DB.PutWithTTL(getKeyHash(key), value, ttl)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.put(key, value, ttl)
 }
 
 // SetBytesK sets the value for a byte slice key with a string value.
@@ -71,21 +177,49 @@ func (c *Cache) SetBytesV(key string, value []byte) error {
 }
 
 // SetBytesKV sets the value for a byte slice key and value.
-// Optimization: Efficient key hashing via getKeyHash.
+// Optimization: Efficient key hashing via the configured Hasher.
 func (c *Cache) SetBytesKV(key []byte, value []byte) error {
-	return c.DB.Put(getKeyHash(key), value)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.put(key, value, 0)
 }
 
-// Close closes the cache database.
+// Close stops background maintenance and closes the cache database.
 // Optimization: Direct passthrough to bitcask.Close.
 func (c *Cache) Close() error {
+	if c.stop != nil {
+		c.stop()
+	}
+	if c.events != nil {
+		c.events.close()
+	}
 	return c.DB.Close()
 }
 
-// DelAll deletes all keys in the cache.
-// Optimization: Relies on bitcask’s efficient deletion.
+// DelAll deletes all keys in the cache. On a root cache this includes every namespace,
+// on a namespace view only the keys of that namespace and its nested namespaces.
+// Optimization: Relies on bitcask’s efficient deletion; namespaces use a prefix scan.
 func (c *Cache) DelAll() error {
-	return c.DB.DeleteAll()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.prefix) == 0 {
+		if err := c.DB.DeleteAll(); err != nil {
+			return err
+		}
+		c.notify(EventClear, nil)
+		return nil
+	}
+	keys, err := c.rawKeys(c.prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := c.DB.Delete(key); err != nil {
+			return err
+		}
+	}
+	c.notify(EventClear, nil)
+	return nil
 }
 
 // Del deletes the given string key from the cache.
@@ -95,19 +229,124 @@ func (c *Cache) Del(key string) error {
 }
 
 // DelBytes deletes the given byte slice key from the cache.
-// Optimization: Efficient key hashing via getKeyHash.
+// Optimization: Efficient key hashing via the configured Hasher.
 func (c *Cache) DelBytes(key []byte) error {
-	return c.DB.Delete(key)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.del(key)
 }
 
-// Len returns the number of keys in the cache.
-// Optimization: Direct passthrough to bitcask.Len.
+// Len returns the number of keys in the cache or namespace.
+// Optimization: Direct passthrough to bitcask.Len for plain root caches, prefix scans otherwise.
 func (c *Cache) Len() int {
-	return c.DB.Len()
+	if !c.keyIndex && len(c.prefix) == 0 {
+		return c.DB.Len()
+	}
+	n := 0
+	if c.keyIndex {
+		c.DB.Scan(c.indexKey(nil), func([]byte) error {
+			n++
+			return nil
+		})
+		return n
+	}
+	c.DB.Scan(c.prefix, func(key []byte) error {
+		if isDataKey(key[len(c.prefix):]) {
+			n++
+		}
+		return nil
+	})
+	return n
 }
 
 // RunGC runs the garbage collector on the cache.
 // Optimization: Direct passthrough to bitcask.RunGC.
 func (c *Cache) RunGC() error {
+	c.notifyExpired()
 	return c.DB.RunGC()
 }
+
+// put stores the value under the hashed key, mirroring the original key into the index when enabled.
+// Writes without a TTL use the default TTL. The caller holds the write lock. Collision-safe caches wrap the value with the original key and refuse to overwrite a different key.
+// Both records are checked against the store's size limits up front, so a rejected write leaves neither behind.
+func (c *Cache) put(key, value []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
+	hash := c.hash(key)
+	size := len(value)
+	value, err := c.encodeValue(key, value)
+	if err != nil {
+		return err
+	}
+	if c.collisionSafe {
+		if stored, err := c.DB.Get(hash); err == nil {
+			if k, _, ok := unwrapKey(stored); ok && bytesutils.B2s(k) != bytesutils.B2s(key) {
+				return ErrKeyCollision
+			}
+		}
+		value = wrapKey(key, value)
+	}
+	if err := c.checkSize(hash, value); err != nil {
+		return err
+	}
+	var ik, meta []byte
+	if c.keyIndex {
+		ik, meta = c.indexKey(key), indexMeta(ttl)
+		if err := c.checkSize(ik, meta); err != nil {
+			return err
+		}
+	}
+	if ttl > 0 {
+		if err := c.DB.PutWithTTL(hash, value, ttl); err != nil {
+			return err
+		}
+	} else if err := c.DB.Put(hash, value); err != nil {
+		return err
+	}
+	if c.keyIndex {
+		if ttl > 0 {
+			err = c.DB.PutWithTTL(ik, meta, ttl)
+		} else {
+			err = c.DB.Put(ik, meta)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.stats != nil {
+		c.stats.sets.Add(1)
+		c.stats.valueBytes.Add(uint64(size))
+	}
+	c.notify(EventSet, key)
+	return nil
+}
+
+// del removes the hashed key and its index entry when enabled; the caller holds the write lock.
+func (c *Cache) del(key []byte) error {
+	return c.remove(key, false)
+}
+
+// remove deletes key like del, counting it as an eviction instead of a delete when evicted is set.
+func (c *Cache) remove(key []byte, evicted bool) error {
+	if c.collisionSafe && !c.has(key) {
+		return nil
+	}
+	if err := c.DB.Delete(c.hash(key)); err != nil {
+		return err
+	}
+	if c.keyIndex {
+		if err := c.DB.Delete(c.indexKey(key)); err != nil {
+			return err
+		}
+	}
+	if c.stats != nil {
+		if evicted {
+			c.stats.evictions.Add(1)
+		} else {
+			c.stats.deletes.Add(1)
+		}
+	}
+	c.notify(EventDelete, key)
+	return nil
+}
@@ -0,0 +1,224 @@
+package cacheutils
+
+import (
+	gbytes "bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of change reported by an Event.
+type EventType uint8
+
+const (
+	// EventSet is sent after a key was written.
+	EventSet EventType = iota + 1
+	// EventDelete is sent after a key was deleted or evicted.
+	EventDelete
+	// EventExpire is sent when a key was found expired by a read or by RunGC.
+	EventExpire
+	// EventClear is sent after DelAll; Key is empty and the whole namespace is affected.
+	EventClear
+)
+
+// String returns the lower-case name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventClear:
+		return "clear"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to a cache entry.
+type Event struct {
+	Type EventType
+	// Namespace is the slash-separated namespace path of the key, empty for the root cache.
+	Namespace string
+	Key       string
+
+	prefix []byte
+}
+
+// subscriber receives the events matching its namespace and key prefix.
+type subscriber struct {
+	prefix    []byte
+	keyPrefix string
+	fn        func(Event)
+}
+
+// notifier fans events out to subscribers from a single dispatcher goroutine,
+// so subscribers never run under the cache lock and may use the cache themselves.
+type notifier struct {
+	mux     sync.Mutex
+	subs    map[uint64]*subscriber
+	next    uint64
+	active  atomic.Int32
+	queue   []Event
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+	once    sync.Once
+}
+
+// newNotifier creates a notifier whose dispatcher starts with the first subscription.
+func newNotifier() *notifier {
+	return &notifier{
+		subs: make(map[uint64]*subscriber),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Subscribe calls fn for every set, delete, expire and clear event of the cache whose key
+// starts with keyPrefix, including events of nested namespaces. Events are delivered in order
+// from a single goroutine shortly after the change. The returned function unsubscribes.
+// Optimization: Writes only pay for an atomic load while there are no subscribers.
+func (c *Cache) Subscribe(keyPrefix string, fn func(Event)) func() {
+	n := c.events
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if !n.started {
+		n.started = true
+		go n.dispatch()
+	}
+	id := n.next
+	n.next++
+	n.subs[id] = &subscriber{prefix: c.prefix, keyPrefix: keyPrefix, fn: fn}
+	n.active.Add(1)
+	return func() {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		if _, ok := n.subs[id]; ok {
+			delete(n.subs, id)
+			n.active.Add(-1)
+		}
+	}
+}
+
+// Watch is like Subscribe but delivers events on a channel with the given buffer size.
+// Events are dropped while the buffer is full. The channel is closed on unsubscribe.
+// Optimization: Non-blocking sends keep a slow reader from stalling other subscribers.
+func (c *Cache) Watch(keyPrefix string, buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	var mux sync.Mutex
+	closed := false
+	unsubscribe := c.Subscribe(keyPrefix, func(ev Event) {
+		mux.Lock()
+		defer mux.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	})
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			unsubscribe()
+			mux.Lock()
+			closed = true
+			close(ch)
+			mux.Unlock()
+		})
+	}
+}
+
+// matches reports whether the subscriber wants the event. Clear events reach every
+// subscriber inside the cleared namespace regardless of its key prefix.
+func (s *subscriber) matches(ev Event) bool {
+	if ev.Type == EventClear {
+		return gbytes.HasPrefix(ev.prefix, s.prefix) || gbytes.HasPrefix(s.prefix, ev.prefix)
+	}
+	return gbytes.HasPrefix(ev.prefix, s.prefix) && strings.HasPrefix(ev.Key, s.keyPrefix)
+}
+
+// notify queues an event for key in the cache's namespace.
+func (c *Cache) notify(t EventType, key []byte) {
+	if c.events == nil || c.events.active.Load() == 0 {
+		return
+	}
+	c.events.publish(Event{Type: t, Namespace: namespacePath(c.prefix), Key: string(key), prefix: c.prefix})
+}
+
+// notifyExpired queues expire events for indexed keys whose TTL has passed.
+func (c *Cache) notifyExpired() {
+	if !c.keyIndex || c.events == nil || c.events.active.Load() == 0 {
+		return
+	}
+	entries, err := c.indexEntries()
+	if err != nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	for _, e := range entries {
+		if e.expiry != 0 && e.expiry <= now {
+			c.events.publish(Event{Type: EventExpire, Namespace: namespacePath(e.prefix), Key: string(e.key), prefix: e.prefix})
+		}
+	}
+}
+
+// publish appends the event to the queue and wakes the dispatcher.
+func (n *notifier) publish(ev Event) {
+	n.mux.Lock()
+	n.queue = append(n.queue, ev)
+	n.mux.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch delivers queued events until close is called.
+func (n *notifier) dispatch() {
+	defer close(n.done)
+	var subs []*subscriber
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.wake:
+		}
+		n.mux.Lock()
+		queue := n.queue
+		n.queue = nil
+		subs = subs[:0]
+		for _, s := range n.subs {
+			subs = append(subs, s)
+		}
+		n.mux.Unlock()
+		for _, ev := range queue {
+			for _, s := range subs {
+				if s.matches(ev) {
+					s.fn(ev)
+				}
+			}
+		}
+	}
+}
+
+// close stops the dispatcher if it was started.
+func (n *notifier) close() {
+	n.once.Do(func() {
+		n.mux.Lock()
+		started := n.started
+		n.started = true
+		n.mux.Unlock()
+		close(n.stop)
+		if started {
+			<-n.done
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package cacheutils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+// ErrKeyCollision is returned when a collision-safe cache refuses to overwrite a different key with the same hash.
+var ErrKeyCollision = errors.New("cacheutils: key hash collision")
+
+// Hasher maps an original key to the key stored in bitcask.
+// Results must not start with a 0x00 or 0x01 byte, which are reserved for index entries and namespaces.
+type Hasher func(key []byte) []byte
+
+// FNV64Hasher hashes keys with 64-bit FNV-1 rendered as decimal text, the historical default.
+// Optimization: Matches existing stores, so no migration is needed.
+func FNV64Hasher(key []byte) []byte {
+	return getKeyHash(key)
+}
+
+// XXHashHasher hashes keys with 64-bit xxHash rendered as hexadecimal text.
+// Optimization: Considerably faster than FNV for long keys.
+func XXHashHasher(key []byte) []byte {
+	return strconv.AppendUint(make([]byte, 0, 16), xxhash.Sum64(key), 16)
+}
+
+// SHA256Hasher hashes keys with SHA-256 rendered as unpadded URL-safe base64 text.
+// Optimization: Collisions are practically impossible; base64 keeps the 43-byte result
+// within bitcask's default key size limit even inside namespaces.
+func SHA256Hasher(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return base64.RawURLEncoding.AppendEncode(make([]byte, 0, base64.RawURLEncoding.EncodedLen(len(sum))), sum[:])
+}
+
+// hash returns the stored key for the original key using the configured hasher and namespace.
+func (c *Cache) hash(key []byte) []byte {
+	h := getKeyHash
+	if c.hasher != nil {
+		h = c.hasher
+	}
+	if len(c.prefix) == 0 {
+		return h(key)
+	}
+	return append(append([]byte(nil), c.prefix...), h(key)...)
+}
+
+// wrapKey prefixes the value with the original key for collision-safe storage.
+func wrapKey(key, value []byte) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+// unwrapKey splits a collision-safe value into its original key and value.
+func unwrapKey(stored []byte) (key, value []byte, ok bool) {
+	n, l := binary.Uvarint(stored)
+	if l <= 0 || uint64(len(stored)-l) < n {
+		return nil, nil, false
+	}
+	return stored[l : l+int(n)], stored[l+int(n):], true
+}
+
+// checkKey reports whether a collision-safe stored value belongs to key and returns its value.
+func checkKey(key, stored []byte) ([]byte, bool) {
+	k, value, ok := unwrapKey(stored)
+	if !ok || bytesutils.B2s(k) != bytesutils.B2s(key) {
+		return nil, false
+	}
+	return value, true
+}
+
+// Migrate copies keys from src into dst, re-encoding them with dst's hasher and collision-safe setting.
+// When no keys are given, src's key index is used. Remaining TTLs are kept when src indexes keys.
+// If both caches share a DB, as with WithOptions, entries are moved from their old hashes.
+// Optimization: Streams entries one at a time, so memory stays bounded by the key list.
+func Migrate(dst, src *Cache, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		var err error
+		if keys, err = src.Keys(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, key := range keys {
+		k := bytesutils.S2b(key)
+		value, err := src.GetBytes(k)
+		if err != nil {
+			continue
+		}
+		ttl := src.ttl(k)
+		if ttl < 0 {
+			continue
+		}
+		if err := migrateOne(dst, src, k, value, ttl); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// migrateOne writes a single entry into dst under its lock, moving it when both caches share a DB.
+func migrateOne(dst, src *Cache, key, value []byte, ttl time.Duration) error {
+	dst.mux.Lock()
+	defer dst.mux.Unlock()
+	if dst.DB != src.DB {
+		return dst.put(key, value, ttl)
+	}
+	if err := src.DB.Delete(src.hash(key)); err != nil {
+		return err
+	}
+	if err := dst.put(key, value, ttl); err != nil {
+		src.put(key, value, ttl)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package cacheutils
+
+import (
+	"encoding/binary"
+	"iter"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+// indexPrefix marks key index entries; hashed keys never start with a zero byte.
+var indexPrefix = []byte{0, 'k'}
+
+// indexMetaLen is the size of an index entry: expiry and modification time in Unix nanoseconds.
+const indexMetaLen = 16
+
+// indexKey returns the index entry key for the original key within the cache's namespace.
+func (c *Cache) indexKey(key []byte) []byte {
+	ik := make([]byte, 0, len(c.prefix)+len(indexPrefix)+len(key))
+	ik = append(ik, c.prefix...)
+	ik = append(ik, indexPrefix...)
+	return append(ik, key...)
+}
+
+// indexMeta encodes the expiry for ttl (zero when none) and the current time.
+func indexMeta(ttl time.Duration) []byte {
+	now := time.Now()
+	meta := make([]byte, indexMetaLen)
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(meta, uint64(now.Add(ttl).UnixNano()))
+	}
+	binary.BigEndian.PutUint64(meta[8:], uint64(now.UnixNano()))
+	return meta
+}
+
+// rawKeys copies the stored keys starting with prefix; an empty prefix matches every key.
+func (c *Cache) rawKeys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	collect := func(key []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	}
+	if len(prefix) == 0 {
+		return keys, c.DB.Fold(collect)
+	}
+	return keys, c.DB.Scan(prefix, collect)
+}
+
+// scanIndex collects the live original keys starting with prefix in lexicographic order.
+func (c *Cache) scanIndex(prefix string) ([]string, error) {
+	if !c.keyIndex {
+		return nil, ErrKeyIndexDisabled
+	}
+	var keys []string
+	skip := len(c.prefix) + len(indexPrefix)
+	err := c.DB.Scan(c.indexKey(bytesutils.S2b(prefix)), func(ik []byte) error {
+		keys = append(keys, string(ik[skip:]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	live := keys[:0]
+	for _, key := range keys {
+		if c.DB.Has(c.indexKey(bytesutils.S2b(key))) {
+			live = append(live, key)
+		}
+	}
+	return live, nil
+}
+
+// Keys returns all original keys stored in the cache in lexicographic order.
+// Optimization: Prefix scan over the in-memory index trie, no value reads.
+func (c *Cache) Keys() ([]string, error) {
+	return c.scanIndex("")
+}
+
+// KeysWithPrefix returns the original keys starting with prefix in lexicographic order.
+// Optimization: Prefix scan over the in-memory index trie, no value reads.
+func (c *Cache) KeysWithPrefix(prefix string) ([]string, error) {
+	return c.scanIndex(prefix)
+}
+
+// Range returns an iterator over the entries whose keys start with prefix.
+// It yields nothing when the key index is disabled; keys deleted during iteration are skipped.
+// Optimization: Keys are collected up front and values are read lazily.
+func (c *Cache) Range(prefix string) iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		keys, err := c.scanIndex(prefix)
+		if err != nil {
+			return
+		}
+		for _, key := range keys {
+			value, err := c.Get(key)
+			if err != nil {
+				continue
+			}
+			if !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over every entry in the cache.
+// Optimization: Same as Range with an empty prefix.
+func (c *Cache) All() iter.Seq2[string, []byte] {
+	return c.Range("")
+}
+
+// ttl returns the remaining time-to-live recorded in the key index: zero when the key
+// never expires or is not indexed, negative when it has already expired.
+func (c *Cache) ttl(key []byte) time.Duration {
+	if !c.keyIndex {
+		return 0
+	}
+	meta, err := c.DB.Get(c.indexKey(key))
+	if err != nil || len(meta) < indexMetaLen {
+		return 0
+	}
+	expiry := int64(binary.BigEndian.Uint64(meta))
+	if expiry == 0 {
+		return 0
+	}
+	if ttl := time.Until(time.Unix(0, expiry)); ttl > 0 {
+		return ttl
+	}
+	return -1
+}
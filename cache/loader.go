@@ -0,0 +1,151 @@
+package cacheutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+const (
+	// loaderMagic starts every loader entry, followed by the soft expiry, so values written
+	// by plain Set calls are never mistaken for loader entries. Its last byte is the format version.
+	loaderMagic = "\xffswr\x01"
+	// loaderHeaderLen is the size of the header stored in front of loader values.
+	loaderHeaderLen = len(loaderMagic) + 8
+)
+
+// ErrLoadPanicked is returned to callers that waited for a load or memoized call which panicked.
+var ErrLoadPanicked = errors.New("cacheutils: load panicked")
+
+// LoaderFunc loads the value for key from the origin when the cache cannot serve it.
+type LoaderFunc func(key string) ([]byte, error)
+
+// loadCall tracks a single in-flight load so concurrent callers share its result.
+type loadCall struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+}
+
+// Loader serves values from a Cache and reloads them through a LoaderFunc.
+// Entries are fresh until SoftTTL, served stale while refreshed in the background
+// until HardTTL, and reloaded synchronously afterwards. Accesses within RefreshAhead
+// of the soft expiry trigger a background refresh of a still-fresh entry.
+type Loader struct {
+	Cache        *Cache
+	Load         LoaderFunc
+	SoftTTL      time.Duration
+	HardTTL      time.Duration
+	RefreshAhead time.Duration
+	OnError      func(key string, err error)
+
+	mux      sync.Mutex
+	inflight map[string]*loadCall
+}
+
+// NewLoader creates a Loader on top of the cache with the given soft and hard TTLs.
+// A non-positive softTTL disables stale serving, a non-positive hardTTL keeps entries forever.
+// Optimization: Lazily allocates the in-flight map on first load.
+func NewLoader(c *Cache, load LoaderFunc, softTTL, hardTTL time.Duration, refreshAhead ...time.Duration) *Loader {
+	ra := time.Duration(0)
+	if len(refreshAhead) > 0 {
+		ra = refreshAhead[0]
+	}
+	return &Loader{
+		Cache:        c,
+		Load:         load,
+		SoftTTL:      softTTL,
+		HardTTL:      hardTTL,
+		RefreshAhead: ra,
+	}
+}
+
+// Get returns the cached value for key, loading it when missing or hard-expired.
+// Stale or nearly stale values are returned immediately and refreshed in the background.
+// Values not written by a Loader are treated as missing and replaced by a load.
+// Optimization: Concurrent loads of the same key are collapsed into one LoaderFunc call.
+func (l *Loader) Get(key string) ([]byte, error) {
+	stored, err := l.Cache.Get(key)
+	if err != nil || len(stored) < loaderHeaderLen || string(stored[:len(loaderMagic)]) != loaderMagic {
+		return l.load(key)
+	}
+	freshUntil := time.Unix(0, int64(binary.BigEndian.Uint64(stored[len(loaderMagic):])))
+	now := time.Now()
+	if !now.Before(freshUntil) || (l.RefreshAhead > 0 && !now.Before(freshUntil.Add(-l.RefreshAhead))) {
+		go l.refresh(key)
+	}
+	return stored[loaderHeaderLen:], nil
+}
+
+// Refresh reloads the value for key through the LoaderFunc and stores it.
+// Optimization: Shares the in-flight load with concurrent Get calls.
+func (l *Loader) Refresh(key string) ([]byte, error) {
+	return l.load(key)
+}
+
+// refresh runs a background reload, reporting failures to OnError.
+func (l *Loader) refresh(key string) {
+	if _, err := l.load(key); err != nil && l.OnError != nil {
+		l.OnError(key, err)
+	}
+}
+
+// load calls the LoaderFunc once per key at a time and stores the result.
+// A panicking LoaderFunc propagates to its caller while waiting callers get ErrLoadPanicked.
+func (l *Loader) load(key string) ([]byte, error) {
+	l.mux.Lock()
+	if l.inflight == nil {
+		l.inflight = make(map[string]*loadCall)
+	}
+	if c, ok := l.inflight[key]; ok {
+		l.mux.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := new(loadCall)
+	c.wg.Add(1)
+	l.inflight[key] = c
+	l.mux.Unlock()
+
+	panicked := true
+	defer func() {
+		if panicked {
+			c.val, c.err = nil, ErrLoadPanicked
+		}
+		l.mux.Lock()
+		delete(l.inflight, key)
+		l.mux.Unlock()
+		c.wg.Done()
+	}()
+	start := time.Now()
+	c.val, c.err = l.Load(key)
+	l.Cache.recordLoad(time.Since(start), c.err)
+	if c.err == nil {
+		c.err = l.store(key, c.val)
+	}
+	panicked = false
+	return c.val, c.err
+}
+
+// store writes the value prefixed with the loader magic and its soft expiry, using the hard TTL for the entry.
+func (l *Loader) store(key string, value []byte) error {
+	freshUntil := int64(math.MaxInt64)
+	switch {
+	case l.SoftTTL > 0:
+		freshUntil = time.Now().Add(l.SoftTTL).UnixNano()
+	case l.HardTTL > 0:
+		freshUntil = time.Now().Add(l.HardTTL).UnixNano()
+	}
+	buf := make([]byte, loaderHeaderLen+len(value))
+	copy(buf, loaderMagic)
+	binary.BigEndian.PutUint64(buf[len(loaderMagic):], uint64(freshUntil))
+	copy(buf[loaderHeaderLen:], value)
+	if l.HardTTL > 0 {
+		return l.Cache.SetBytesKVWithTTL(bytesutils.S2b(key), buf, l.HardTTL)
+	}
+	return l.Cache.SetBytesKV(bytesutils.S2b(key), buf)
+}
@@ -0,0 +1,197 @@
+package cacheutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"git.mills.io/prologic/bitcask"
+)
+
+// DefaultMaintenanceInterval is used when Maintenance.Interval is not positive.
+const DefaultMaintenanceInterval = 10 * time.Minute
+
+// Maintenance configures the periodic upkeep run by WithMaintenance or Maintain.
+type Maintenance struct {
+	// Interval between maintenance passes.
+	Interval time.Duration
+	// MaxDeadRatio triggers a merge when reclaimable space exceeds this share of the disk size.
+	MaxDeadRatio float64
+	// MaxSize caps the disk size in bytes by evicting the least recently written entries.
+	// Eviction needs WithKeyIndex, since write times are recorded in the key index.
+	// Queue messages and memoized results, which MemoizeOptions.MaxSize bounds instead,
+	// are never evicted.
+	MaxSize int64
+	// OnError receives errors of background passes.
+	OnError func(error)
+}
+
+// indexEntry is a key index record found while walking all namespaces.
+type indexEntry struct {
+	prefix   []byte
+	key      []byte
+	expiry   int64
+	modified int64
+}
+
+// Maintain runs a single maintenance pass: expired keys are collected, the store is merged
+// when its dead space exceeds MaxDeadRatio, and the oldest entries are evicted while it exceeds MaxSize.
+// Optimization: Stats are read once per step and merges only run when they reclaim enough space.
+func (c *Cache) Maintain(m Maintenance) error {
+	if err := c.RunGC(); err != nil {
+		return err
+	}
+	stats, err := c.DB.Stats()
+	if err != nil {
+		return err
+	}
+	merged := false
+	if m.MaxDeadRatio > 0 && stats.Size > 0 && float64(c.DB.Reclaimable())/float64(stats.Size) >= m.MaxDeadRatio {
+		if err := c.merge(); err != nil {
+			return err
+		}
+		merged = true
+	}
+	if m.MaxSize <= 0 || !c.keyIndex {
+		return nil
+	}
+	if merged {
+		if stats, err = c.DB.Stats(); err != nil {
+			return err
+		}
+	}
+	if stats.Size <= m.MaxSize {
+		return nil
+	}
+	if _, err := c.evictOldest(1 - float64(m.MaxSize)/float64(stats.Size)); err != nil {
+		return err
+	}
+	return c.merge()
+}
+
+// merge compacts the datafiles, tolerating a merge that is already running.
+func (c *Cache) merge() error {
+	if err := c.DB.Merge(); err != nil && !errors.Is(err, bitcask.ErrMergeInProgress) {
+		return err
+	}
+	return nil
+}
+
+// evictOldest deletes the given share of indexed entries across all namespaces except pinned ones,
+// oldest writes first. Entry sizes are assumed to be uniform, so the freed space is approximate.
+func (c *Cache) evictOldest(share float64) (int, error) {
+	entries, err := c.indexEntries()
+	if err != nil {
+		return 0, err
+	}
+	entries = slices.DeleteFunc(entries, func(e indexEntry) bool {
+		return isPinned(e.prefix)
+	})
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	slices.SortFunc(entries, func(a, b indexEntry) int {
+		switch {
+		case a.modified < b.modified:
+			return -1
+		case a.modified > b.modified:
+			return 1
+		}
+		return 0
+	})
+	n := int(float64(len(entries))*share) + 1
+	if n > len(entries) {
+		n = len(entries)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, e := range entries[:n] {
+		v := *c
+		v.prefix = e.prefix
+		if err := v.remove(e.key, true); err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// indexEntries collects the key index records of the root cache and every namespace.
+// Entries that already expired are reported with an expiry in the distant past.
+func (c *Cache) indexEntries() ([]indexEntry, error) {
+	raws, err := c.rawKeys(nil)
+	if err != nil {
+		return nil, err
+	}
+	var entries []indexEntry
+	for _, raw := range raws {
+		prefix, key, ok := splitIndexKey(raw)
+		if !ok {
+			continue
+		}
+		meta, err := c.DB.Get(raw)
+		if errors.Is(err, bitcask.ErrKeyExpired) {
+			entries = append(entries, indexEntry{prefix: prefix, key: key, expiry: 1})
+			continue
+		}
+		if err != nil || len(meta) < indexMetaLen {
+			continue
+		}
+		entries = append(entries, indexEntry{
+			prefix:   prefix,
+			key:      key,
+			expiry:   int64(binary.BigEndian.Uint64(meta)),
+			modified: int64(binary.BigEndian.Uint64(meta[8:])),
+		})
+	}
+	return entries, nil
+}
+
+// splitIndexKey splits a raw index key into its namespace prefix and original key.
+func splitIndexKey(raw []byte) (prefix, key []byte, ok bool) {
+	i := 0
+	for i < len(raw) && raw[i] == namespaceMarker {
+		n, l := binary.Uvarint(raw[i+1:])
+		if l <= 0 {
+			return nil, nil, false
+		}
+		i += 1 + l + int(n)
+	}
+	if i+len(indexPrefix) > len(raw) || string(raw[i:i+len(indexPrefix)]) != string(indexPrefix) {
+		return nil, nil, false
+	}
+	return raw[:i], raw[i+len(indexPrefix):], true
+}
+
+// startMaintenance runs Maintain every interval until the returned function is called.
+func (c *Cache) startMaintenance(m Maintenance) func() {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultMaintenanceInterval
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := c.Maintain(m); err != nil && m.OnError != nil {
+					m.OnError(err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package cacheutils
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+const (
+	// memoNamespace is the pinned namespace holding the results of Cache-backed memoized functions.
+	memoNamespace = "memo"
+	// memoNameLen and memoKeyLen are the digest sizes of function names and arguments, which keep
+	// stored keys and their index entries well within bitcask's default key size limit.
+	memoNameLen = 8
+	memoKeyLen  = 16
+)
+
+// MemoizeOptions configures Memoize.
+type MemoizeOptions struct {
+	// TTL bounds how long a result is reused; zero keeps results until evicted.
+	TTL time.Duration
+	// MaxSize bounds the number of results kept, evicting the least recently used; zero means unbounded.
+	// With a Cache store, results from earlier runs are only counted when the cache has WithKeyIndex.
+	MaxSize int
+	// Cache stores results persistently instead of in memory, in a private namespace per Name that
+	// Maintenance never evicts. Arguments are rendered with %#v and hashed into fixed-length keys,
+	// results are encoded as JSON, so both should be plain data.
+	Cache *Cache
+	// Name identifies the function's results in Cache across runs. It defaults to the function's
+	// symbol name, which changes when closures are renumbered, so set it for closures.
+	Name string
+}
+
+// memoStore keeps memoized results.
+type memoStore[K comparable, V any] interface {
+	get(key K) (V, bool)
+	set(key K, value V) error
+}
+
+// memoCall tracks a single in-flight call so concurrent callers share its result.
+type memoCall[V any] struct {
+	wg  sync.WaitGroup
+	val V
+	err error
+}
+
+// Memoize wraps fn so results for the same argument are reused. Errors are not cached and
+// concurrent calls with the same argument share a single call of fn. A result that cannot be
+// stored in Cache is returned together with the store error. If fn panics, the panic reaches
+// its caller and the calls waiting for it return ErrLoadPanicked.
+// Optimization: In-flight deduplication keeps bursts of identical calls to one execution.
+func Memoize[K comparable, V any](fn func(K) (V, error), opts MemoizeOptions) func(K) (V, error) {
+	var store memoStore[K, V]
+	if opts.Cache != nil {
+		name := memoDigest(memoName(opts, fn), memoNameLen)
+		store = newMemoCache[K, V](opts.Cache.pinned(memoNamespace).Namespace(name, WithDefaultTTL(0)), opts.TTL, opts.MaxSize)
+	} else {
+		store = &memoLRU[K, V]{ttl: opts.TTL, max: opts.MaxSize, items: make(map[K]*list.Element)}
+	}
+	var (
+		mux      sync.Mutex
+		inflight = make(map[K]*memoCall[V])
+	)
+	return func(key K) (V, error) {
+		if v, ok := store.get(key); ok {
+			return v, nil
+		}
+		mux.Lock()
+		if c, ok := inflight[key]; ok {
+			mux.Unlock()
+			c.wg.Wait()
+			return c.val, c.err
+		}
+		c := new(memoCall[V])
+		c.wg.Add(1)
+		inflight[key] = c
+		mux.Unlock()
+
+		panicked := true
+		defer func() {
+			if panicked {
+				c.err = ErrLoadPanicked
+			}
+			mux.Lock()
+			delete(inflight, key)
+			mux.Unlock()
+			c.wg.Done()
+		}()
+		c.val, c.err = fn(key)
+		if c.err == nil {
+			c.err = store.set(key, c.val)
+		}
+		panicked = false
+		return c.val, c.err
+	}
+}
+
+// memoName returns the name of fn's results in a Cache store.
+func memoName(opts MemoizeOptions, fn any) string {
+	if opts.Name != "" {
+		return opts.Name
+	}
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// memoDigest renders the first n bytes of the SHA-256 of s as unpadded URL-safe base64.
+func memoDigest(s string, n int) string {
+	sum := sha256.Sum256(bytesutils.S2b(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:n])
+}
+
+// memoArgs2 is the combined key of a two-argument function.
+type memoArgs2[A, B comparable] struct {
+	A A
+	B B
+}
+
+// memoArgs3 is the combined key of a three-argument function.
+type memoArgs3[A, B, C comparable] struct {
+	A A
+	B B
+	C C
+}
+
+// Memoize2 is Memoize for functions of two arguments.
+// Optimization: Arguments are combined into a comparable struct key without allocation.
+func Memoize2[A, B comparable, V any](fn func(A, B) (V, error), opts MemoizeOptions) func(A, B) (V, error) {
+	opts.Name = memoName(opts, fn)
+	m := Memoize(func(k memoArgs2[A, B]) (V, error) {
+		return fn(k.A, k.B)
+	}, opts)
+	return func(a A, b B) (V, error) {
+		return m(memoArgs2[A, B]{a, b})
+	}
+}
+
+// Memoize3 is Memoize for functions of three arguments.
+// Optimization: Arguments are combined into a comparable struct key without allocation.
+func Memoize3[A, B, C comparable, V any](fn func(A, B, C) (V, error), opts MemoizeOptions) func(A, B, C) (V, error) {
+	opts.Name = memoName(opts, fn)
+	m := Memoize(func(k memoArgs3[A, B, C]) (V, error) {
+		return fn(k.A, k.B, k.C)
+	}, opts)
+	return func(a A, b B, c C) (V, error) {
+		return m(memoArgs3[A, B, C]{a, b, c})
+	}
+}
+
+// memoEntry is a result kept by memoLRU.
+type memoEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// memoLRU keeps results in memory, evicting the least recently used beyond max.
+type memoLRU[K comparable, V any] struct {
+	mux   sync.Mutex
+	ttl   time.Duration
+	max   int
+	order list.List
+	items map[K]*list.Element
+}
+
+// get returns a live result and marks it as recently used.
+func (s *memoLRU[K, V]) get(key K) (v V, ok bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return v, false
+	}
+	e := el.Value.(*memoEntry[K, V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		s.order.Remove(el)
+		delete(s.items, key)
+		return v, false
+	}
+	s.order.MoveToFront(el)
+	return e.value, true
+}
+
+// set stores a result, evicting the least recently used one when full.
+func (s *memoLRU[K, V]) set(key K, value V) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	e := &memoEntry[K, V]{key: key, value: value}
+	if s.ttl > 0 {
+		e.expires = time.Now().Add(s.ttl)
+	}
+	if el, ok := s.items[key]; ok {
+		el.Value = e
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.order.PushFront(e)
+	if s.max > 0 && s.order.Len() > s.max {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoEntry[K, V]).key)
+	}
+	return nil
+}
+
+// memoCache keeps results in a Cache as JSON, evicting the least recently used beyond max.
+type memoCache[K comparable, V any] struct {
+	c     *Cache
+	ttl   time.Duration
+	max   int
+	mux   sync.Mutex
+	order list.List
+	items map[string]*list.Element
+}
+
+// newMemoCache creates a store in the function's private namespace c, tracking the results
+// of earlier runs when bounded.
+func newMemoCache[K comparable, V any](c *Cache, ttl time.Duration, max int) *memoCache[K, V] {
+	s := &memoCache[K, V]{c: c, ttl: ttl, max: max, items: make(map[string]*list.Element)}
+	if max > 0 {
+		keys, _ := c.Keys()
+		for _, key := range keys {
+			s.items[key] = s.order.PushFront(key)
+		}
+		s.evict()
+	}
+	return s
+}
+
+// get decodes a stored result and marks it as recently used.
+func (s *memoCache[K, V]) get(key K) (v V, ok bool) {
+	k := memoKey(key)
+	b, err := s.c.Get(k)
+	if err != nil || json.Unmarshal(b, &v) != nil {
+		return v, false
+	}
+	s.touch(k)
+	return v, true
+}
+
+// set encodes and stores a result.
+func (s *memoCache[K, V]) set(key K, value V) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	k := memoKey(key)
+	if err := s.c.SetBytesKVWithTTL(bytesutils.S2b(k), b, s.ttl); err != nil {
+		return err
+	}
+	s.touch(k)
+	return nil
+}
+
+// memoKey hashes the rendered argument into a fixed-length stored key.
+func memoKey[K comparable](key K) string {
+	return memoDigest(fmt.Sprintf("%#v", key), memoKeyLen)
+}
+
+// touch marks a stored key as recently used and evicts beyond max.
+func (s *memoCache[K, V]) touch(key string) {
+	if s.max <= 0 {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.order.PushFront(key)
+	s.evict()
+}
+
+// evict deletes the least recently used keys beyond max; the caller holds the lock.
+func (s *memoCache[K, V]) evict() {
+	for s.order.Len() > s.max {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		key := oldest.Value.(string)
+		delete(s.items, key)
+		s.c.Del(key)
+	}
+}
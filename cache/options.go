@@ -0,0 +1,87 @@
+package cacheutils
+
+import (
+	"crypto/cipher"
+	"time"
+
+	"git.mills.io/prologic/bitcask"
+)
+
+// Option configures a Cache created by New.
+type Option func(*Cache)
+
+// WithKeyIndex keeps an index of original keys next to their hashes,
+// enabling Keys, Range and All at the cost of one extra write per Set.
+// Index entries are stored under the original key, so keys longer than bitcask's key size
+// limit minus the namespace prefix are rejected; raise the limit with WithBitcaskOptions.
+// Optimization: Index entries carry only a small fixed-size metadata record.
+func WithKeyIndex() Option {
+	return func(c *Cache) {
+		c.keyIndex = true
+	}
+}
+
+// WithHasher sets the function used to derive stored keys; FNV64Hasher is used by default.
+// Changing the hasher of an existing store requires Migrate.
+// Optimization: The hasher is called once per operation with no extra allocations.
+func WithHasher(h Hasher) Option {
+	return func(c *Cache) {
+		c.hasher = h
+	}
+}
+
+// WithCollisionSafe stores the original key alongside each value and verifies it on reads,
+// so colliding keys are reported instead of silently overwriting each other.
+// Optimization: Costs one extra read per write and a short key prefix per value.
+func WithCollisionSafe() Option {
+	return func(c *Cache) {
+		c.collisionSafe = true
+	}
+}
+
+// WithDefaultTTL sets the time-to-live used by writes that do not specify one.
+// Combined with Namespace it gives each namespace its own expiry policy.
+// Optimization: Applied once per write with no extra storage.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithCompression compresses values of at least minSize bytes with the given codec.
+// Values that do not shrink are stored uncompressed; entries written before are still readable.
+// Optimization: Small values skip compression entirely, avoiding its fixed overhead.
+func WithCompression(codec Codec, minSize int) Option {
+	return func(c *Cache) {
+		c.codec = codec
+		c.compressMin = minSize
+	}
+}
+
+// WithEncryption encrypts values with the given AEAD, typically from NewAESGCM.
+// The original key is authenticated with each value, so values cannot be swapped between keys.
+// Optimization: The nonce is stored inline, so no extra entries are written.
+func WithEncryption(aead cipher.AEAD) Option {
+	return func(c *Cache) {
+		c.aead = aead
+	}
+}
+
+// WithMaintenance runs Maintain in the background every m.Interval until Close.
+// It only takes effect when passed to New.
+// Optimization: A single goroutine driven by a ticker, idle between passes.
+func WithMaintenance(m Maintenance) Option {
+	return func(c *Cache) {
+		c.maintenance = &m
+	}
+}
+
+// WithBitcaskOptions passes options such as bitcask.WithMaxKeySize to the underlying store.
+// Indexed caches need a key size limit that fits their longest original key.
+// It only takes effect when passed to New.
+// Optimization: Options are handed to bitcask.Open as-is.
+func WithBitcaskOptions(opts ...bitcask.Option) Option {
+	return func(c *Cache) {
+		c.dbOptions = append(c.dbOptions, opts...)
+	}
+}
@@ -0,0 +1,349 @@
+package cacheutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+)
+
+var (
+	// ErrQueueEmpty is returned by Dequeue when no message is ready for delivery.
+	ErrQueueEmpty = errors.New("cacheutils: queue is empty")
+	// ErrNotInFlight is returned by Ack, Nack and Extend when the message was already acknowledged,
+	// dead-lettered or redelivered after its visibility timeout.
+	ErrNotInFlight = errors.New("cacheutils: message is not in flight")
+)
+
+// errStopScan ends an index scan early.
+var errStopScan = errors.New("cacheutils: stop scan")
+
+const (
+	// DefaultVisibilityTimeout is how long a dequeued message stays hidden when Queue.Visibility is zero.
+	DefaultVisibilityTimeout = 30 * time.Second
+
+	// queueNamespace holds every queue's namespace.
+	queueNamespace = "queue"
+	// queueSeqKey holds the last assigned message ID.
+	queueSeqKey = "seq"
+	// queueReadyPrefix starts the keys of pending and in-flight messages.
+	queueReadyPrefix = "m/"
+	// queueDeadPrefix starts the keys of dead-lettered messages.
+	queueDeadPrefix = "d/"
+	// queueHeaderLen is the size of a stored message header: attempts, visible-at and enqueued-at.
+	queueHeaderLen = 20
+	// queueScanBatch is the initial number of message keys examined per Dequeue scan.
+	queueScanBatch = 64
+)
+
+// Message is a message delivered by a Queue.
+type Message struct {
+	ID         uint64
+	Body       []byte
+	Attempts   int
+	EnqueuedAt time.Time
+}
+
+// Queue is a durable FIFO work queue stored in a namespace of a Cache.
+// Dequeued messages stay hidden for the visibility timeout and are redelivered unless acknowledged;
+// messages delivered MaxAttempts times without acknowledgement move to the dead-letter list.
+type Queue struct {
+	c *Cache
+	// Visibility is how long a dequeued message stays hidden; zero means DefaultVisibilityTimeout.
+	Visibility time.Duration
+	// MaxAttempts is the number of deliveries before a message is dead-lettered; zero means unlimited.
+	MaxAttempts int
+}
+
+// NewQueue opens the queue called name in the cache, with an optional maximum number of delivery attempts.
+// Messages never expire, whatever the default TTL of the cache, are never evicted by Maintenance,
+// and survive restarts.
+// Optimization: The queue is a namespace view sharing the cache's DB and lock.
+func NewQueue(c *Cache, name string, visibility time.Duration, maxAttempts ...int) *Queue {
+	q := &Queue{
+		c:          c.pinned(queueNamespace).Namespace(name, WithKeyIndex(), WithDefaultTTL(0)),
+		Visibility: visibility,
+	}
+	if len(maxAttempts) > 0 {
+		q.MaxAttempts = maxAttempts[0]
+	}
+	return q
+}
+
+// Enqueue appends body to the queue, optionally hidden for a delay, and returns its message ID.
+// Optimization: ID assignment and write happen under a single write lock.
+func (q *Queue) Enqueue(body []byte, delay ...time.Duration) (uint64, error) {
+	now := time.Now()
+	visibleAt := now
+	if len(delay) > 0 && delay[0] > 0 {
+		visibleAt = now.Add(delay[0])
+	}
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	id := uint64(1)
+	if seq, err := q.c.get(bytesutils.S2b(queueSeqKey)); err == nil {
+		n, err := strconv.ParseUint(bytesutils.B2s(seq), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		id = n + 1
+	}
+	if err := q.c.put(bytesutils.S2b(queueSeqKey), strconv.AppendUint(nil, id, 10), 0); err != nil {
+		return 0, err
+	}
+	if err := q.c.put(queueKey(queueReadyPrefix, id), encodeMessage(0, visibleAt, now, body), 0); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Dequeue delivers the oldest message that is ready, hiding it for the visibility timeout.
+// Messages whose last delivery timed out after MaxAttempts are dead-lettered instead.
+// It returns ErrQueueEmpty when no message is ready.
+// Optimization: Scans the ordered key index in growing batches, so the head of the queue is found without reading the rest.
+func (q *Queue) Dequeue() (*Message, error) {
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	now := time.Now()
+	for limit := queueScanBatch; ; limit *= 2 {
+		keys, more, err := q.c.firstIndexKeys(queueReadyPrefix, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			value, err := q.c.get(bytesutils.S2b(key))
+			if err != nil {
+				continue
+			}
+			m, visibleAt, ok := decodeMessage(key, value)
+			if !ok || visibleAt.After(now) {
+				continue
+			}
+			if q.MaxAttempts > 0 && m.Attempts >= q.MaxAttempts {
+				if err := q.deadLetter(m); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			m.Attempts++
+			if err := q.c.put(bytesutils.S2b(key), encodeMessage(m.Attempts, now.Add(q.visibility()), m.EnqueuedAt, m.Body), 0); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+		if !more {
+			return nil, ErrQueueEmpty
+		}
+	}
+}
+
+// Ack removes a delivered message from the queue.
+// Optimization: Receipt check and deletion happen under a single write lock.
+func (q *Queue) Ack(m *Message) error {
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	if _, err := q.inFlight(m); err != nil {
+		return err
+	}
+	return q.c.del(queueKey(queueReadyPrefix, m.ID))
+}
+
+// Nack returns a delivered message to the queue, visible again after an optional delay.
+// A message that used up MaxAttempts is dead-lettered instead.
+// Optimization: Receipt check and write happen under a single write lock.
+func (q *Queue) Nack(m *Message, delay ...time.Duration) error {
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	stored, err := q.inFlight(m)
+	if err != nil {
+		return err
+	}
+	if q.MaxAttempts > 0 && stored.Attempts >= q.MaxAttempts {
+		return q.deadLetter(stored)
+	}
+	visibleAt := time.Now()
+	if len(delay) > 0 && delay[0] > 0 {
+		visibleAt = visibleAt.Add(delay[0])
+	}
+	return q.c.put(queueKey(queueReadyPrefix, m.ID), encodeMessage(stored.Attempts, visibleAt, stored.EnqueuedAt, stored.Body), 0)
+}
+
+// Extend keeps a delivered message hidden for d from now, for consumers needing more time.
+// Optimization: Receipt check and write happen under a single write lock.
+func (q *Queue) Extend(m *Message, d time.Duration) error {
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	stored, err := q.inFlight(m)
+	if err != nil {
+		return err
+	}
+	return q.c.put(queueKey(queueReadyPrefix, m.ID), encodeMessage(stored.Attempts, time.Now().Add(d), stored.EnqueuedAt, stored.Body), 0)
+}
+
+// Len returns the number of pending and in-flight messages, excluding dead letters.
+// Optimization: Counts index keys without reading values.
+func (q *Queue) Len() (int, error) {
+	q.c.mux.RLock()
+	defer q.c.mux.RUnlock()
+	keys, err := q.c.scanIndex(queueReadyPrefix)
+	return len(keys), err
+}
+
+// DeadLetters returns the dead-lettered messages in ID order.
+// Optimization: Reads every message under a single read lock.
+func (q *Queue) DeadLetters() ([]*Message, error) {
+	q.c.mux.RLock()
+	defer q.c.mux.RUnlock()
+	keys, err := q.c.scanIndex(queueDeadPrefix)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0, len(keys))
+	for _, key := range keys {
+		value, err := q.c.get(bytesutils.S2b(key))
+		if err != nil {
+			continue
+		}
+		if m, _, ok := decodeMessage(key, value); ok {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// Redrive moves dead-lettered messages back into the queue with their attempts reset,
+// all of them when no IDs are given, and returns how many were moved.
+// Optimization: Every move happens under a single write lock.
+func (q *Queue) Redrive(ids ...uint64) (int, error) {
+	q.c.mux.Lock()
+	defer q.c.mux.Unlock()
+	if len(ids) == 0 {
+		keys, err := q.c.scanIndex(queueDeadPrefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			id, err := strconv.ParseUint(key[len(queueDeadPrefix):], 10, 64)
+			if err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	n := 0
+	now := time.Now()
+	for _, id := range ids {
+		key := queueKey(queueDeadPrefix, id)
+		value, err := q.c.get(key)
+		if err != nil {
+			continue
+		}
+		m, _, ok := decodeMessage(bytesutils.B2s(key), value)
+		if !ok {
+			continue
+		}
+		if err := q.c.put(queueKey(queueReadyPrefix, id), encodeMessage(0, now, m.EnqueuedAt, m.Body), 0); err != nil {
+			return n, err
+		}
+		if err := q.c.del(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Purge removes every message of the queue, including dead letters and the ID sequence.
+// Optimization: Delegates to DelAll on the queue's namespace.
+func (q *Queue) Purge() error {
+	return q.c.DelAll()
+}
+
+// visibility returns the effective visibility timeout.
+func (q *Queue) visibility() time.Duration {
+	if q.Visibility > 0 {
+		return q.Visibility
+	}
+	return DefaultVisibilityTimeout
+}
+
+// inFlight returns the stored message if m is still its latest delivery; the caller holds the write lock.
+func (q *Queue) inFlight(m *Message) (*Message, error) {
+	key := queueKey(queueReadyPrefix, m.ID)
+	value, err := q.c.get(key)
+	if err != nil {
+		return nil, ErrNotInFlight
+	}
+	stored, _, ok := decodeMessage(bytesutils.B2s(key), value)
+	if !ok || stored.Attempts != m.Attempts || stored.Attempts == 0 {
+		return nil, ErrNotInFlight
+	}
+	return stored, nil
+}
+
+// deadLetter moves the message to the dead-letter list; the caller holds the write lock.
+func (q *Queue) deadLetter(m *Message) error {
+	if err := q.c.put(queueKey(queueDeadPrefix, m.ID), encodeMessage(m.Attempts, time.Time{}, m.EnqueuedAt, m.Body), 0); err != nil {
+		return err
+	}
+	return q.c.del(queueKey(queueReadyPrefix, m.ID))
+}
+
+// firstIndexKeys collects up to limit live original keys starting with prefix in lexicographic order,
+// reporting whether more keys may follow; the caller holds the lock.
+func (c *Cache) firstIndexKeys(prefix string, limit int) ([]string, bool, error) {
+	if !c.keyIndex {
+		return nil, false, ErrKeyIndexDisabled
+	}
+	var keys []string
+	more := false
+	skip := len(c.prefix) + len(indexPrefix)
+	err := c.DB.Scan(c.indexKey(bytesutils.S2b(prefix)), func(ik []byte) error {
+		if len(keys) == limit {
+			more = true
+			return errStopScan
+		}
+		keys = append(keys, string(ik[skip:]))
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopScan) {
+		return nil, false, err
+	}
+	return keys, more, nil
+}
+
+// queueKey returns the key of message id under prefix, zero-padded so keys sort by ID.
+func queueKey(prefix string, id uint64) []byte {
+	return fmt.Appendf(nil, "%s%020d", prefix, id)
+}
+
+// encodeMessage lays out a stored message: attempts, visible-at, enqueued-at and body.
+func encodeMessage(attempts int, visibleAt, enqueuedAt time.Time, body []byte) []byte {
+	b := make([]byte, queueHeaderLen, queueHeaderLen+len(body))
+	binary.BigEndian.PutUint32(b, uint32(attempts))
+	if !visibleAt.IsZero() {
+		binary.BigEndian.PutUint64(b[4:], uint64(visibleAt.UnixNano()))
+	}
+	binary.BigEndian.PutUint64(b[12:], uint64(enqueuedAt.UnixNano()))
+	return append(b, body...)
+}
+
+// decodeMessage parses a stored message under key, returning it with its visible-at time.
+func decodeMessage(key string, value []byte) (*Message, time.Time, bool) {
+	if len(value) < queueHeaderLen || len(key) < len(queueReadyPrefix) {
+		return nil, time.Time{}, false
+	}
+	id, err := strconv.ParseUint(key[len(queueReadyPrefix):], 10, 64)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	m := &Message{
+		ID:         id,
+		Body:       value[queueHeaderLen:],
+		Attempts:   int(binary.BigEndian.Uint32(value)),
+		EnqueuedAt: time.Unix(0, int64(binary.BigEndian.Uint64(value[12:]))),
+	}
+	return m, time.Unix(0, int64(binary.BigEndian.Uint64(value[4:]))), true
+}
@@ -0,0 +1,218 @@
+package cacheutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, opts ...Option) *Cache {
+	t.Helper()
+	c, err := New(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// mustDequeue dequeues a message, failing the test when none is ready.
+func mustDequeue(t *testing.T, q *Queue) *Message {
+	t.Helper()
+	m, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	return m
+}
+
+func TestQueueDeliversInOrder(t *testing.T) {
+	q := NewQueue(newTestCache(t), "jobs", time.Minute)
+	for _, body := range []string{"a", "b", "c"} {
+		if _, err := q.Enqueue([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		m := mustDequeue(t, q)
+		if string(m.Body) != want || m.Attempts != 1 {
+			t.Fatalf("got %q after %d attempts, want %q after 1", m.Body, m.Attempts, want)
+		}
+		if err := q.Ack(m); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	if _, err := q.Dequeue(); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("Dequeue on empty queue: %v, want ErrQueueEmpty", err)
+	}
+	if n, err := q.Len(); err != nil || n != 0 {
+		t.Fatalf("Len = %d, %v, want 0", n, err)
+	}
+}
+
+func TestQueueDelayedEnqueue(t *testing.T) {
+	q := NewQueue(newTestCache(t), "jobs", time.Minute)
+	q.Enqueue([]byte("later"), time.Hour)
+	q.Enqueue([]byte("now"))
+	if m := mustDequeue(t, q); string(m.Body) != "now" {
+		t.Fatalf("got %q, want the message without delay", m.Body)
+	}
+	if _, err := q.Dequeue(); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("delayed message delivered early: %v", err)
+	}
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	q := NewQueue(newTestCache(t), "jobs", 50*time.Millisecond)
+	q.Enqueue([]byte("a"))
+	first := mustDequeue(t, q)
+	if _, err := q.Dequeue(); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("in-flight message redelivered before its timeout: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	second := mustDequeue(t, q)
+	if second.ID != first.ID || second.Attempts != 2 {
+		t.Fatalf("redelivered ID %d after %d attempts, want ID %d after 2", second.ID, second.Attempts, first.ID)
+	}
+	if err := q.Ack(first); !errors.Is(err, ErrNotInFlight) {
+		t.Fatalf("Ack of a timed-out delivery: %v, want ErrNotInFlight", err)
+	}
+	if err := q.Extend(second, time.Hour); err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := q.Dequeue(); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("extended message redelivered: %v", err)
+	}
+	if err := q.Ack(second); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := q.Ack(second); !errors.Is(err, ErrNotInFlight) {
+		t.Fatalf("second Ack: %v, want ErrNotInFlight", err)
+	}
+}
+
+func TestQueueNack(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   []time.Duration
+		visible bool
+	}{
+		{"immediately", nil, true},
+		{"zero delay", []time.Duration{0}, true},
+		{"delayed", []time.Duration{time.Hour}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(newTestCache(t), "jobs", time.Hour)
+			q.Enqueue([]byte("a"))
+			m := mustDequeue(t, q)
+			if err := q.Nack(m, tt.delay...); err != nil {
+				t.Fatalf("Nack: %v", err)
+			}
+			again, err := q.Dequeue()
+			if !tt.visible {
+				if !errors.Is(err, ErrQueueEmpty) {
+					t.Fatalf("Dequeue after delayed Nack: %v, want ErrQueueEmpty", err)
+				}
+				return
+			}
+			if err != nil || again.ID != m.ID || again.Attempts != 2 {
+				t.Fatalf("Dequeue after Nack: %+v, %v", again, err)
+			}
+			if err := q.Nack(m); !errors.Is(err, ErrNotInFlight) {
+				t.Fatalf("Nack of an earlier delivery: %v, want ErrNotInFlight", err)
+			}
+		})
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail ends a delivery without acknowledging it.
+		fail func(q *Queue, m *Message)
+	}{
+		{"nack", func(q *Queue, m *Message) { q.Nack(m) }},
+		{"visibility timeout", func(q *Queue, m *Message) { time.Sleep(100 * time.Millisecond) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(newTestCache(t), "jobs", 50*time.Millisecond, 2)
+			id, _ := q.Enqueue([]byte("poison"))
+			q.Enqueue([]byte("ok"), time.Hour)
+			for range 2 {
+				tt.fail(q, mustDequeue(t, q))
+			}
+			if _, err := q.Dequeue(); !errors.Is(err, ErrQueueEmpty) {
+				t.Fatalf("Dequeue after MaxAttempts: %v, want ErrQueueEmpty", err)
+			}
+			dead, err := q.DeadLetters()
+			if err != nil || len(dead) != 1 || dead[0].ID != id || string(dead[0].Body) != "poison" || dead[0].Attempts != 2 {
+				t.Fatalf("DeadLetters = %+v, %v", dead, err)
+			}
+			if n, _ := q.Len(); n != 1 {
+				t.Fatalf("Len = %d, want 1 excluding dead letters", n)
+			}
+
+			if n, err := q.Redrive(); err != nil || n != 1 {
+				t.Fatalf("Redrive = %d, %v, want 1", n, err)
+			}
+			if dead, _ := q.DeadLetters(); len(dead) != 0 {
+				t.Fatalf("DeadLetters after Redrive = %+v", dead)
+			}
+			m := mustDequeue(t, q)
+			if m.ID != id || m.Attempts != 1 {
+				t.Fatalf("redriven message ID %d after %d attempts, want ID %d after 1", m.ID, m.Attempts, id)
+			}
+		})
+	}
+}
+
+func TestQueueRedriveByID(t *testing.T) {
+	q := NewQueue(newTestCache(t), "jobs", time.Minute, 1)
+	a, _ := q.Enqueue([]byte("a"))
+	b, _ := q.Enqueue([]byte("b"))
+	q.Nack(mustDequeue(t, q))
+	q.Nack(mustDequeue(t, q))
+	if n, err := q.Redrive(b, 42); err != nil || n != 1 {
+		t.Fatalf("Redrive = %d, %v, want 1 for the known ID", n, err)
+	}
+	dead, _ := q.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != a {
+		t.Fatalf("DeadLetters = %+v, want only message %d", dead, a)
+	}
+	if m := mustDequeue(t, q); m.ID != b {
+		t.Fatalf("got message %d, want %d", m.ID, b)
+	}
+}
+
+func TestQueueSurvivesReopenAndPurge(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, WithDefaultTTL(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewQueue(c, "jobs", time.Minute).Enqueue([]byte("a"))
+	c.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	c, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	q := NewQueue(c, "jobs", time.Minute)
+	if m := mustDequeue(t, q); string(m.Body) != "a" {
+		t.Fatalf("got %q after reopening, want %q", m.Body, "a")
+	}
+	if err := q.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Fatalf("Len after Purge = %d", n)
+	}
+	if id, _ := q.Enqueue([]byte("b")); id != 1 {
+		t.Fatalf("ID after Purge = %d, want the sequence restarted at 1", id)
+	}
+}
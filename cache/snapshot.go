@@ -0,0 +1,172 @@
+package cacheutils
+
+import (
+	"bufio"
+	gbytes "bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"git.mills.io/prologic/bitcask"
+)
+
+// snapshotMagic starts every snapshot written by Export.
+const snapshotMagic = "GUCS"
+
+// SnapshotVersion is the snapshot format version written by Export.
+const SnapshotVersion = 1
+
+const (
+	recordEnd byte = iota
+	// recordEntry holds a namespace prefix, original key, decoded value and expiry.
+	recordEntry
+)
+
+var (
+	// ErrInvalidSnapshot is returned by Import when the input is not a snapshot.
+	ErrInvalidSnapshot = errors.New("cacheutils: invalid snapshot")
+	// ErrSnapshotVersion is returned by Import for snapshots newer than this build understands.
+	ErrSnapshotVersion = errors.New("cacheutils: unsupported snapshot version")
+)
+
+// Export writes every live entry of the cache, including nested namespaces, to w.
+// Entries are written with their original keys, decoded values and expiry, so they can be
+// imported into caches with other hashers or transformers. Expiry and original keys are only
+// known to the key index, so caches without WithKeyIndex return ErrKeyIndexDisabled.
+// Optimization: Streams records through a buffered writer, one value in memory at a time.
+func (c *Cache) Export(w io.Writer) error {
+	if !c.keyIndex {
+		return ErrKeyIndexDisabled
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(SnapshotVersion)
+	if err := c.exportEntries(bw); err != nil {
+		return err
+	}
+	bw.WriteByte(recordEnd)
+	return bw.Flush()
+}
+
+// exportEntries writes the indexed entries below the cache's namespace.
+func (c *Cache) exportEntries(bw *bufio.Writer) error {
+	entries, err := c.indexEntries()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	for _, e := range entries {
+		if !gbytes.HasPrefix(e.prefix, c.prefix) || (e.expiry != 0 && e.expiry <= now) {
+			continue
+		}
+		v := *c
+		v.prefix = e.prefix
+		v.mux.RLock()
+		value, err := v.get(e.key)
+		v.mux.RUnlock()
+		if err != nil {
+			continue
+		}
+		bw.WriteByte(recordEntry)
+		writeField(bw, e.prefix[len(c.prefix):])
+		writeField(bw, e.key)
+		var buf [binary.MaxVarintLen64]byte
+		bw.Write(buf[:binary.PutVarint(buf[:], e.expiry)])
+		if err := writeField(bw, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import reads a snapshot written by Export into the cache, below its namespace.
+// Entries are re-encoded with the cache's own hasher and transformers; expired entries are skipped.
+// Fields longer than the store's value size limit are rejected with ErrInvalidSnapshot.
+// Optimization: Streams records through a buffered reader, one value in memory at a time.
+func (c *Cache) Import(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	if header[len(snapshotMagic)] > SnapshotVersion {
+		return ErrSnapshotVersion
+	}
+	for {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return ErrInvalidSnapshot
+		}
+		switch kind {
+		case recordEnd:
+			return nil
+		case recordEntry:
+			err = c.importEntry(br)
+		default:
+			return ErrInvalidSnapshot
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// importEntry reads one entry record and stores it with its remaining TTL.
+func (c *Cache) importEntry(br *bufio.Reader) error {
+	prefix, err := c.readField(br)
+	if err != nil {
+		return err
+	}
+	key, err := c.readField(br)
+	if err != nil {
+		return err
+	}
+	expiry, err := binary.ReadVarint(br)
+	if err != nil {
+		return ErrInvalidSnapshot
+	}
+	value, err := c.readField(br)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(0)
+	if expiry != 0 {
+		if ttl = time.Until(time.Unix(0, expiry)); ttl <= 0 {
+			return nil
+		}
+	}
+	v := *c
+	v.prefix = append(append([]byte(nil), c.prefix...), prefix...)
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.put(key, value, ttl)
+}
+
+// writeField writes a length-prefixed byte field.
+func writeField(bw *bufio.Writer, b []byte) error {
+	var buf [binary.MaxVarintLen64]byte
+	bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(b)))])
+	_, err := bw.Write(b)
+	return err
+}
+
+// readField reads a length-prefixed byte field, rejecting lengths beyond the store's value size limit.
+func (c *Cache) readField(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	limit := c.maxValueSize
+	if limit == 0 {
+		limit = bitcask.DefaultMaxValueSize
+	}
+	if n > limit {
+		return nil, ErrInvalidSnapshot
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	return b, nil
+}
@@ -0,0 +1,119 @@
+package cacheutils
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters of a cache, shared by all its namespaces.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Sets      uint64
+	Deletes   uint64
+	Evictions uint64
+	// Loads counts LoaderFunc calls made by Loaders on top of the cache, LoadErrors the failed ones.
+	Loads      uint64
+	LoadErrors uint64
+	// ValueBytes is the total size of written values before compression and encryption.
+	ValueBytes uint64
+	// LoadTime is the total time spent in LoaderFunc calls.
+	LoadTime time.Duration
+}
+
+// HitRate returns the share of reads that found a value, or zero before the first read.
+// Optimization: Pure arithmetic on the snapshot.
+func (s Stats) HitRate() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// AvgValueSize returns the average size in bytes of written values.
+// Optimization: Pure arithmetic on the snapshot.
+func (s Stats) AvgValueSize() float64 {
+	if s.Sets == 0 {
+		return 0
+	}
+	return float64(s.ValueBytes) / float64(s.Sets)
+}
+
+// AvgLoadLatency returns the average duration of a LoaderFunc call.
+// Optimization: Pure arithmetic on the snapshot.
+func (s Stats) AvgLoadLatency() time.Duration {
+	if s.Loads == 0 {
+		return 0
+	}
+	return s.LoadTime / time.Duration(s.Loads)
+}
+
+// counters holds the live statistics of a cache.
+type counters struct {
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	sets       atomic.Uint64
+	deletes    atomic.Uint64
+	evictions  atomic.Uint64
+	loads      atomic.Uint64
+	loadErrors atomic.Uint64
+	valueBytes atomic.Uint64
+	loadTime   atomic.Int64
+}
+
+// Stats returns a snapshot of the cache's hit, miss, write, eviction and load counters.
+// Optimization: Lock-free atomic loads; the snapshot is not taken atomically across counters.
+func (c *Cache) Stats() Stats {
+	s := c.stats
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:       s.hits.Load(),
+		Misses:     s.misses.Load(),
+		Sets:       s.sets.Load(),
+		Deletes:    s.deletes.Load(),
+		Evictions:  s.evictions.Load(),
+		Loads:      s.loads.Load(),
+		LoadErrors: s.loadErrors.Load(),
+		ValueBytes: s.valueBytes.Load(),
+		LoadTime:   time.Duration(s.loadTime.Load()),
+	}
+}
+
+// ResetStats sets all counters back to zero.
+// Optimization: Lock-free atomic stores.
+func (c *Cache) ResetStats() {
+	s := c.stats
+	if s == nil {
+		return
+	}
+	for _, n := range []*atomic.Uint64{&s.hits, &s.misses, &s.sets, &s.deletes, &s.evictions, &s.loads, &s.loadErrors, &s.valueBytes} {
+		n.Store(0)
+	}
+	s.loadTime.Store(0)
+}
+
+// recordRead counts a read as a hit or miss.
+func (c *Cache) recordRead(hit bool) {
+	if c.stats == nil {
+		return
+	}
+	if hit {
+		c.stats.hits.Add(1)
+	} else {
+		c.stats.misses.Add(1)
+	}
+}
+
+// recordLoad counts a LoaderFunc call and its duration.
+func (c *Cache) recordLoad(d time.Duration, err error) {
+	if c.stats == nil {
+		return
+	}
+	c.stats.loads.Add(1)
+	c.stats.loadTime.Add(int64(d))
+	if err != nil {
+		c.stats.loadErrors.Add(1)
+	}
+}
@@ -0,0 +1,190 @@
+package cacheutils
+
+import (
+	gbytes "bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec selects the compression applied to cached values.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecZstd
+	CodecSnappy
+)
+
+const (
+	// valueMagic starts values written by the transform layer. 0xFE and 0xC1 never occur in UTF-8,
+	// so legacy text values are never mistaken for transformed ones.
+	valueMagic = "\xfe\xc1tv"
+	// valueHeaderLen is the size of the magic plus the flags byte.
+	valueHeaderLen  = len(valueMagic) + 1
+	headerEncrypted = 0x08
+	headerCodecMask = 0x07
+	// headerReserved must be zero in a valid flags byte.
+	headerReserved = 0xF0
+)
+
+var (
+	// ErrValueEncrypted is returned when reading an encrypted value from a cache without an AEAD.
+	ErrValueEncrypted = errors.New("cacheutils: value is encrypted")
+	// ErrUnknownCodec is returned when a value header names a codec this build cannot decode.
+	ErrUnknownCodec = errors.New("cacheutils: unknown value codec")
+	// ErrCorruptValue is returned when an encrypted value is too short to contain its nonce.
+	ErrCorruptValue = errors.New("cacheutils: corrupt value")
+
+	gzipWriterPool = sync.Pool{
+		New: func() any {
+			return gzip.NewWriter(nil)
+		},
+	}
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// NewAESGCM returns an AES-GCM AEAD for WithEncryption from a 16, 24 or 32 byte key.
+// Optimization: Uses the standard library's hardware-accelerated AES-GCM.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// transforms reports whether values are compressed or encrypted on write.
+func (c *Cache) transforms() bool {
+	return c.codec != CodecNone || c.aead != nil
+}
+
+// transformed reports whether stored starts with a valid transform header.
+func transformed(stored []byte) bool {
+	return len(stored) >= valueHeaderLen && string(stored[:len(valueMagic)]) == valueMagic &&
+		stored[len(valueMagic)]&headerReserved == 0
+}
+
+// encodeValue compresses values of at least the threshold size and encrypts them when configured,
+// prefixing the result with a header describing both steps. Plain values that happen to start
+// with a transform header are wrapped in an empty header so they read back unchanged.
+func (c *Cache) encodeValue(key, value []byte) ([]byte, error) {
+	if !c.transforms() {
+		if transformed(value) {
+			return append([]byte(valueMagic+"\x00"), value...), nil
+		}
+		return value, nil
+	}
+	flags := byte(0)
+	if c.codec != CodecNone && len(value) >= c.compressMin {
+		compressed, err := compress(c.codec, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(value) {
+			flags |= byte(c.codec)
+			value = compressed
+		}
+	}
+	if c.aead == nil {
+		buf := make([]byte, valueHeaderLen, valueHeaderLen+len(value))
+		copy(buf, valueMagic)
+		buf[len(valueMagic)] = flags
+		return append(buf, value...), nil
+	}
+	flags |= headerEncrypted
+	ns := c.aead.NonceSize()
+	buf := make([]byte, valueHeaderLen+ns, valueHeaderLen+ns+len(value)+c.aead.Overhead())
+	copy(buf, valueMagic)
+	buf[len(valueMagic)] = flags
+	if _, err := io.ReadFull(rand.Reader, buf[valueHeaderLen:]); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(buf, buf[valueHeaderLen:], value, additionalData(flags, key)), nil
+}
+
+// decodeValue reverses encodeValue whatever transforms the cache is configured with, so values
+// compressed by another configuration still decode. Values without a transform header, such as
+// entries written before transforms were enabled, are returned unchanged.
+func (c *Cache) decodeValue(key, stored []byte) ([]byte, error) {
+	if !transformed(stored) {
+		return stored, nil
+	}
+	flags, value := stored[len(valueMagic)], stored[valueHeaderLen:]
+	if flags&headerEncrypted != 0 {
+		if c.aead == nil {
+			return nil, ErrValueEncrypted
+		}
+		ns := c.aead.NonceSize()
+		if len(value) < ns {
+			return nil, ErrCorruptValue
+		}
+		var err error
+		if value, err = c.aead.Open(nil, value[:ns], value[ns:], additionalData(flags, key)); err != nil {
+			return nil, err
+		}
+	}
+	return decompress(Codec(flags&headerCodecMask), value)
+}
+
+// additionalData binds an encrypted value to its header and original key.
+func additionalData(flags byte, key []byte) []byte {
+	buf := make([]byte, 0, valueHeaderLen+len(key))
+	buf = append(buf, valueMagic...)
+	buf = append(buf, flags)
+	return append(buf, key...)
+}
+
+// compress encodes value with the given codec.
+func compress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecGzip:
+		var buf gbytes.Buffer
+		w := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(value, nil), nil
+	case CodecSnappy:
+		return snappy.Encode(nil, value), nil
+	default:
+		return value, nil
+	}
+}
+
+// decompress decodes value written with the given codec.
+func decompress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return value, nil
+	case CodecGzip:
+		r, err := gzip.NewReader(gbytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CodecZstd:
+		return zstdDecoder.DecodeAll(value, nil)
+	case CodecSnappy:
+		return snappy.Decode(nil, value)
+	default:
+		return nil, ErrUnknownCodec
+	}
+}
@@ -4,8 +4,11 @@ go 1.24.1
 
 require (
 	git.mills.io/prologic/bitcask v1.0.2
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/dgrr/cookiejar v0.0.0-20181027163754-344320c9f75e
+	github.com/gofrs/flock v0.8.0
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.17.11
 	github.com/malisit/kolpa v0.0.0-20201024193526-315f7b3afa5d
 	github.com/mileusna/useragent v1.3.5
 	github.com/tatsushid/go-fastping v0.0.0-20160109021039-d7bb493dee3e
@@ -15,8 +18,6 @@ require (
 require (
 	github.com/abcum/lcp v0.0.0-20201209214815-7a3f3840be81 // indirect
 	github.com/andybalholm/brotli v1.1.1 // indirect
-	github.com/gofrs/flock v0.8.0 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/plar/go-adaptive-radix-tree v1.0.4 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
@@ -6,7 +6,7 @@ import (
 	bytesutils "github.com/sudosz/go-utils/bytes"
 )
 
-const maxIntBufferSize = 19
+const maxIntBufferSize = 20
 
 // Int2Hex converts an integer to a 4-digit hexadecimal string with leading zeros.
 // Optimization: Uses strconv.AppendUint for efficient conversion.
@@ -37,20 +37,20 @@ func Int64ToBytes(i int64) []byte {
 	}
 	var buf [maxIntBufferSize]byte
 	idx := maxIntBufferSize - 1
-	negative := 1
+	u := uint64(i)
 	if i < 0 {
-		negative = 0
-		i = -i
+		u = uint64(-i)
 	}
-	for i > 0 {
-		buf[idx] = byte(i%10) + '0'
-		i /= 10
+	for u > 0 {
+		buf[idx] = byte(u%10) + '0'
+		u /= 10
 		idx--
 	}
-	if negative == 0 {
+	if i < 0 {
 		buf[idx] = '-'
+		idx--
 	}
-	return buf[idx+negative:]
+	return buf[idx+1:]
 }
 
 // Int64ToString converts an int64 to a string using Int64ToBytes.
@@ -0,0 +1,146 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrEmptyChain = errors.New("proxy chain has no hops")
+
+// HopError reports which hop of a proxy chain failed. Hop is the 0-based index of the proxy
+// that failed: connecting to the first proxy fails at hop 0, and hop i failing means proxy i
+// could not be reached through or could not reach Addr.
+type HopError struct {
+	Hop   int
+	Proxy *Proxy
+	Addr  string
+	Err   error
+}
+
+// Error describes the failing hop and its cause.
+func (e *HopError) Error() string {
+	return fmt.Sprintf("proxy chain hop %d (%s) to %s: %v", e.Hop, e.Proxy, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// ChainHop is a proxy of a chain with its own handshake timeout.
+type ChainHop struct {
+	Proxy *Proxy
+	// Timeout bounds reaching the next address through this proxy; zero means the chain's Timeout.
+	Timeout time.Duration
+}
+
+// ChainDialer dials TCP connections through a chain of proxies, e.g. SOCKS5 then HTTP CONNECT.
+// The connection to each proxy is used as the transport of the next proxy's handshake.
+type ChainDialer struct {
+	Hops []ChainHop
+	// Dialer connects to the first proxy; nil means a zero net.Dialer.
+	Dialer ContextDialer
+	// Timeout is the default per-hop timeout; zero means hops are bounded only by the context.
+	Timeout time.Duration
+}
+
+// NewChainDialer creates a ChainDialer through the proxies in order, each validated up front.
+// Optimization: Validation happens once here instead of on every dial.
+func NewChainDialer(proxies ...*Proxy) (*ChainDialer, error) {
+	if len(proxies) == 0 {
+		return nil, ErrEmptyChain
+	}
+	d := &ChainDialer{Hops: make([]ChainHop, len(proxies))}
+	for i, p := range proxies {
+		if err := p.Validate(); err != nil {
+			return nil, &HopError{Hop: i, Proxy: p, Addr: p.Addr, Err: err}
+		}
+		d.Hops[i].Proxy = p
+	}
+	return d, nil
+}
+
+// Dial connects to addr through the chain.
+// Optimization: Same as DialContext with a background context.
+func (d *ChainDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the first proxy and performs each hop's handshake in turn over the
+// same connection, returning a *HopError naming the hop that failed.
+// Optimization: A single TCP connection carries every hop; no intermediate copying.
+func (d *ChainDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedNetwork, network)
+	}
+	if len(d.Hops) == 0 {
+		return nil, ErrEmptyChain
+	}
+	first := d.Hops[0]
+	hopCtx, cancel := d.hopContext(ctx, first)
+	conn, err := dialer(d.Dialer).DialContext(hopCtx, "tcp", first.Proxy.Addr)
+	cancel()
+	if err != nil {
+		return nil, &HopError{Hop: 0, Proxy: first.Proxy, Addr: first.Proxy.Addr, Err: err}
+	}
+	for i, hop := range d.Hops {
+		next, nextNetwork := addr, network
+		if i+1 < len(d.Hops) {
+			// Legs to intermediate proxies are plain TCP whatever family the caller asked for.
+			next, nextNetwork = d.Hops[i+1].Proxy.Addr, "tcp"
+		}
+		if conn, err = d.through(ctx, hop, conn, nextNetwork, next); err != nil {
+			return nil, &HopError{Hop: i, Proxy: hop.Proxy, Addr: next, Err: err}
+		}
+	}
+	return conn, nil
+}
+
+// through runs the hop's handshake over conn to reach next, closing conn on failure.
+func (d *ChainDialer) through(ctx context.Context, hop ChainHop, conn net.Conn, network, next string) (net.Conn, error) {
+	hd, err := hop.Proxy.Dialer(&connDialer{conn: conn})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ctx, cancel := d.hopContext(ctx, hop)
+	defer cancel()
+	c, err := hd.DialContext(ctx, network, next)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// hopContext bounds ctx by the hop's timeout, falling back to the chain's.
+func (d *ChainDialer) hopContext(ctx context.Context, hop ChainHop) (context.Context, context.CancelFunc) {
+	timeout := hop.Timeout
+	if timeout <= 0 {
+		timeout = d.Timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// connDialer hands out an already established connection once, so a proxy dialer runs its handshake over it.
+type connDialer struct {
+	conn net.Conn
+}
+
+// DialContext returns the connection on the first call and an error afterwards.
+func (d *connDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.conn == nil {
+		return nil, net.ErrClosed
+	}
+	conn := d.conn
+	d.conn = nil
+	return conn, nil
+}
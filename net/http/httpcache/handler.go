@@ -0,0 +1,203 @@
+package httpcache
+
+import (
+	gbytes "bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	cacheutils "github.com/sudosz/go-utils/cache"
+)
+
+// Handler is a shared HTTP cache in front of an http.Handler, storing responses in a cacheutils.Cache.
+// Fresh responses are served without calling Next, including 304 Not Modified for matching
+// conditional requests; stale or missing ones are regenerated by Next and streamed to the client.
+// Only responses with explicit freshness (max-age, s-maxage, Expires or public) and without
+// Set-Cookie are stored, since they are served to every client.
+type Handler struct {
+	Next  http.Handler
+	Cache *cacheutils.Cache
+	// StaleTTL is how long entries with validators are kept past their freshness for max-stale requests;
+	// zero means DefaultStaleTTL.
+	StaleTTL time.Duration
+	// OnError receives cache write errors, which never fail the request.
+	OnError func(error)
+}
+
+// NewHandler wraps next with a shared response cache.
+// Optimization: Single allocation; responses are buffered only when they are stored.
+func NewHandler(c *cacheutils.Cache, next http.Handler) *Handler {
+	return &Handler{Next: next, Cache: c}
+}
+
+// ServeHTTP serves the request from the cache or from Next, storing cacheable responses
+// and invalidating entries after successful unsafe requests.
+// Optimization: Fresh hits never call Next.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.Next.ServeHTTP(sw, r)
+		if isUnsafe(r.Method) && sw.status < http.StatusBadRequest {
+			invalidate(h.Cache, r, w.Header())
+		}
+		return
+	}
+	reqCC := parseCacheControl(r.Header)
+	if r.Header.Get("Range") == "" {
+		if e, ok := load(h.Cache, r); ok && e.freshness(r, true, time.Now()) == stateFresh {
+			e.serve(w, r, cacheHit)
+			return
+		}
+	}
+	if reqCC.has("only-if-cached") {
+		w.Header().Set(XCache, cacheMiss)
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		w.Header().Set(XCache, cacheMiss)
+		h.Next.ServeHTTP(w, r)
+		return
+	}
+	rec := &recorder{ResponseWriter: w, req: r}
+	requestTime := time.Now()
+	h.Next.ServeHTTP(rec, r)
+	rec.WriteHeader(http.StatusOK)
+	if !rec.store {
+		return
+	}
+	e := &entry{
+		status:       rec.status,
+		header:       rec.header,
+		body:         rec.body.Bytes(),
+		requestTime:  requestTime,
+		responseTime: time.Now(),
+		varied:       http.Header{},
+	}
+	staleTTL := h.StaleTTL
+	if staleTTL <= 0 {
+		staleTTL = DefaultStaleTTL
+	}
+	if err := store(h.Cache, r, e, true, staleTTL); err != nil && h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+// serve writes the stored response, or 304 Not Modified when the request's validators match.
+func (e *entry) serve(w http.ResponseWriter, r *http.Request, status string) {
+	header := w.Header()
+	for name, values := range e.header {
+		header[name] = values
+	}
+	e.setAge(header, time.Now())
+	header.Set(XCache, status)
+	if e.notModified(r) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since against the stored validators.
+func (e *entry) notModified(r *http.Request) bool {
+	if e.status != http.StatusOK {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(e.header.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// recorder passes the response generated by the wrapped handler through to the client,
+// keeping a copy of it when the response will be stored.
+type recorder struct {
+	http.ResponseWriter
+	req    *http.Request
+	status int
+	store  bool
+	header http.Header
+	body   gbytes.Buffer
+}
+
+// WriteHeader decides whether the response will be stored and sends the header of the first final status.
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		rec.ResponseWriter.WriteHeader(status)
+		return
+	}
+	rec.status = status
+	header := rec.ResponseWriter.Header()
+	if rec.store = storable(rec.req, status, header, true); rec.store {
+		rec.header = header.Clone()
+	}
+	header.Set(XCache, cacheMiss)
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends the body to the client, implying a 200 status, and keeps a copy when it will be stored.
+// A failed write leaves a truncated body, which is then not stored.
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	n, err := rec.ResponseWriter.Write(b)
+	if err != nil {
+		rec.store = false
+	}
+	if rec.store {
+		rec.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Flush sends the header and any buffered body to the client, so streamed responses are not held back.
+func (rec *recorder) Flush() {
+	rec.WriteHeader(http.StatusOK)
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code and forwards it.
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = status, true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,393 @@
+package httpcache
+
+import (
+	"bufio"
+	gbytes "bytes"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	bytesutils "github.com/sudosz/go-utils/bytes"
+	cacheutils "github.com/sudosz/go-utils/cache"
+)
+
+const (
+	// DefaultStaleTTL is how long entries with validators are kept past their freshness for revalidation.
+	DefaultStaleTTL = 24 * time.Hour
+	// XCache is the response header reporting how the cache served a response.
+	XCache = "X-Cache"
+
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+
+	metaRequestTime  = "Request-Time"
+	metaResponseTime = "Response-Time"
+	metaVaryPrefix   = "Vary-"
+
+	// heuristicFraction of the time since Last-Modified is used as freshness when none is given.
+	heuristicFraction = 10
+	maxHeuristic      = 24 * time.Hour
+)
+
+// heuristicStatuses are the status codes cacheable by default (RFC 9110, section 15.1).
+var heuristicStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// freshnessState is the outcome of checking a stored response against a request.
+type freshnessState int
+
+const (
+	stateStale freshnessState = iota
+	stateFresh
+)
+
+// cacheControl holds parsed Cache-Control directives.
+type cacheControl map[string]string
+
+// parseCacheControl parses the Cache-Control header, falling back to Pragma: no-cache.
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	if len(cc) == 0 && strings.Contains(strings.ToLower(h.Get("Pragma")), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+// has reports whether the directive is present.
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the directive value as a duration and whether it was present and valid.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// entry is a stored response together with the metadata needed to judge its freshness.
+type entry struct {
+	status       int
+	header       http.Header
+	body         []byte
+	requestTime  time.Time
+	responseTime time.Time
+	varied       http.Header
+}
+
+// cacheKey identifies the stored response for a request by its absolute URL.
+func cacheKey(req *http.Request) string {
+	if req.URL.IsAbs() {
+		return req.URL.String()
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
+
+// encode serializes the entry as a metadata header block followed by the response in wire format.
+func (e *entry) encode() []byte {
+	var buf gbytes.Buffer
+	meta := http.Header{}
+	meta.Set(metaRequestTime, strconv.FormatInt(e.requestTime.UnixNano(), 10))
+	meta.Set(metaResponseTime, strconv.FormatInt(e.responseTime.UnixNano(), 10))
+	for name, values := range e.varied {
+		meta[metaVaryPrefix+name] = values
+	}
+	meta.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.WriteString("HTTP/1.1 ")
+	buf.WriteString(strconv.Itoa(e.status))
+	buf.WriteString("\r\n")
+	e.header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(e.body)
+	return buf.Bytes()
+}
+
+// decodeEntry parses an entry written by encode.
+func decodeEntry(b []byte) (*entry, bool) {
+	r := textproto.NewReader(bufio.NewReader(gbytes.NewReader(b)))
+	meta, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, false
+	}
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, false
+	}
+	_, code, _ := strings.Cut(line, " ")
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, false
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+	body, err := io.ReadAll(r.R)
+	if err != nil {
+		return nil, false
+	}
+	e := &entry{status: status, header: http.Header(header), body: body, varied: http.Header{}}
+	reqNanos, _ := strconv.ParseInt(meta.Get(metaRequestTime), 10, 64)
+	respNanos, _ := strconv.ParseInt(meta.Get(metaResponseTime), 10, 64)
+	e.requestTime, e.responseTime = time.Unix(0, reqNanos), time.Unix(0, respNanos)
+	for name, values := range meta {
+		if strings.HasPrefix(name, metaVaryPrefix) {
+			e.varied[strings.TrimPrefix(name, metaVaryPrefix)] = values
+		}
+	}
+	if e.header == nil {
+		e.header = http.Header{}
+	}
+	return e, true
+}
+
+// varyFields returns the canonical request header names listed in the response's Vary header.
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, line := range h.Values("Vary") {
+		for _, f := range strings.Split(line, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, http.CanonicalHeaderKey(f))
+			}
+		}
+	}
+	return fields
+}
+
+// matchesVary reports whether the request carries the same values for every varied header.
+func (e *entry) matchesVary(req *http.Request) bool {
+	for _, f := range varyFields(e.header) {
+		if strings.Join(req.Header.Values(f), ",") != strings.Join(e.varied.Values(f), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// lifetime computes the freshness lifetime of the response (RFC 9111, section 4.2.1).
+func (e *entry) lifetime(shared bool) time.Duration {
+	cc := parseCacheControl(e.header)
+	if shared {
+		if d, ok := cc.seconds("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date := e.date()
+	if expires := e.header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if lm, err := http.ParseTime(e.header.Get("Last-Modified")); err == nil && heuristicStatuses[e.status] && date.After(lm) {
+		return min(date.Sub(lm)/heuristicFraction, maxHeuristic)
+	}
+	return 0
+}
+
+// date returns the Date header, or the response time when it is missing or invalid.
+func (e *entry) date() time.Time {
+	if t, err := http.ParseTime(e.header.Get("Date")); err == nil {
+		return t
+	}
+	return e.responseTime
+}
+
+// age computes the current age of the response (RFC 9111, section 4.2.3).
+func (e *entry) age(now time.Time) time.Duration {
+	apparent := max(e.responseTime.Sub(e.date()), 0)
+	ageValue := time.Duration(0)
+	if n, err := strconv.ParseInt(e.header.Get("Age"), 10, 64); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+	corrected := ageValue + e.responseTime.Sub(e.requestTime)
+	return max(apparent, corrected) + now.Sub(e.responseTime)
+}
+
+// freshness decides whether the stored response may be served for the request without revalidation.
+func (e *entry) freshness(req *http.Request, shared bool, now time.Time) freshnessState {
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(e.header)
+	if reqCC.has("no-cache") || respCC.has("no-cache") {
+		return stateStale
+	}
+	lifetime, age := e.lifetime(shared), e.age(now)
+	if d, ok := reqCC.seconds("max-age"); ok && age > d {
+		return stateStale
+	}
+	if d, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < d {
+		return stateStale
+	}
+	if age < lifetime {
+		return stateFresh
+	}
+	if respCC.has("must-revalidate") || (shared && (respCC.has("proxy-revalidate") || respCC.has("s-maxage"))) {
+		return stateStale
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if v == "" {
+			return stateFresh
+		}
+		if d, ok := reqCC.seconds("max-stale"); ok && age-lifetime <= d {
+			return stateFresh
+		}
+	}
+	return stateStale
+}
+
+// hasValidators reports whether the response can be revalidated with a conditional request.
+func (e *entry) hasValidators() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+// storable reports whether a response to the request may be stored (RFC 9111, section 3).
+func storable(req *http.Request, status int, header http.Header, shared bool) bool {
+	if req.Method != http.MethodGet || status == http.StatusPartialContent {
+		return false
+	}
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if shared && respCC.has("private") {
+		return false
+	}
+	if shared && req.Header.Get("Authorization") != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+	for _, f := range varyFields(header) {
+		if f == "*" {
+			return false
+		}
+	}
+	explicit := respCC.has("max-age") || respCC.has("public") || header.Get("Expires") != ""
+	if shared {
+		// A shared cache serves one client's response to others, so it never stores cookies
+		// and never guesses freshness for responses that did not opt into caching.
+		return header.Get("Set-Cookie") == "" && (explicit || respCC.has("s-maxage"))
+	}
+	return explicit || heuristicStatuses[status]
+}
+
+// store writes the response into the cache, keeping entries with validators for staleTTL past their freshness.
+// Shared caches store a copy without Set-Cookie, which can still arrive through a 304 merge.
+func store(c *cacheutils.Cache, req *http.Request, e *entry, shared bool, staleTTL time.Duration) error {
+	if shared && e.header.Get("Set-Cookie") != "" {
+		cp := *e
+		cp.header = e.header.Clone()
+		cp.header.Del("Set-Cookie")
+		e = &cp
+	}
+	e.header.Del("Transfer-Encoding")
+	e.header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	for _, f := range varyFields(e.header) {
+		e.varied[f] = req.Header.Values(f)
+	}
+	ttl := e.lifetime(shared) - e.age(time.Now())
+	if e.hasValidators() {
+		ttl += staleTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return c.SetBytesKVWithTTL(bytesutils.S2b(cacheKey(req)), e.encode(), ttl)
+}
+
+// load returns the stored response for the request if it matches the request's varied headers.
+func load(c *cacheutils.Cache, req *http.Request) (*entry, bool) {
+	b, err := c.Get(cacheKey(req))
+	if err != nil {
+		return nil, false
+	}
+	e, ok := decodeEntry(b)
+	if !ok || !e.matchesVary(req) {
+		return nil, false
+	}
+	return e, true
+}
+
+// invalidate drops the stored responses for the request URL and the same-origin
+// Location and Content-Location targets after a successful unsafe request (RFC 9111, section 4.4).
+func invalidate(c *cacheutils.Cache, req *http.Request, header http.Header) {
+	c.Del(cacheKey(req))
+	for _, name := range [...]string{"Location", "Content-Location"} {
+		v := header.Get(name)
+		if v == "" {
+			continue
+		}
+		u, err := req.URL.Parse(v)
+		if err != nil || (u.Host != "" && u.Host != req.URL.Host && u.Host != req.Host) {
+			continue
+		}
+		target := req.Clone(req.Context())
+		target.URL = u
+		c.Del(cacheKey(target))
+	}
+}
+
+// isUnsafe reports whether the method can change the state of the origin.
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// updateHeaders merges the headers of a 304 response into the stored ones (RFC 9111, section 3.2).
+func (e *entry) updateHeaders(h http.Header) {
+	for name, values := range h {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		e.header[name] = values
+	}
+}
+
+// setAge writes the Age header for the response's current age.
+func (e *entry) setAge(h http.Header, now time.Time) {
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+}
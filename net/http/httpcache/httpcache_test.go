@@ -0,0 +1,220 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	cacheutils "github.com/sudosz/go-utils/cache"
+)
+
+func newCache(t *testing.T) *cacheutils.Cache {
+	t.Helper()
+	c, err := cacheutils.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// origin returns a handler running fn and counting its calls.
+func origin(calls *atomic.Int32, fn func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fn(w, r)
+	})
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestHandlerServesFreshResponses(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hello")
+	}))
+	if rec := serve(h, httptest.NewRequest(http.MethodGet, "/a", nil)); rec.Header().Get(XCache) != cacheMiss {
+		t.Fatalf("first request: X-Cache = %q, want MISS", rec.Header().Get(XCache))
+	}
+	rec := serve(h, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if rec.Header().Get(XCache) != cacheHit || rec.Body.String() != "hello" {
+		t.Fatalf("second request: X-Cache = %q, body = %q", rec.Header().Get(XCache), rec.Body.String())
+	}
+	if rec.Header().Get("Age") == "" {
+		t.Fatal("hit without Age header")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("origin called %d times, want 1", calls.Load())
+	}
+}
+
+func TestHandlerDoesNotStoreImplicitlyFreshResponses(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		io.WriteString(w, "page")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/plain", nil))
+	r := httptest.NewRequest(http.MethodGet, "/plain", nil)
+	r.Header.Set("Cache-Control", "max-stale")
+	if rec := serve(h, r); rec.Header().Get(XCache) != cacheMiss {
+		t.Fatalf("X-Cache = %q, want MISS", rec.Header().Get(XCache))
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("origin called %d times, want 2", calls.Load())
+	}
+}
+
+func TestHandlerDoesNotShareCookies(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Set-Cookie", "session="+user)
+		io.WriteString(w, "hello "+user)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("X-User", "alice")
+	if rec := serve(h, r); rec.Header().Get("Set-Cookie") != "session=alice" {
+		t.Fatalf("Set-Cookie = %q, want the client's own cookie", rec.Header().Get("Set-Cookie"))
+	}
+	r = httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("X-User", "bob")
+	r.Header.Set("Cache-Control", "max-stale")
+	rec := serve(h, r)
+	if rec.Header().Get(XCache) != cacheMiss || rec.Body.String() != "hello bob" || rec.Header().Get("Set-Cookie") != "session=bob" {
+		t.Fatalf("X-Cache = %q, body = %q, Set-Cookie = %q", rec.Header().Get(XCache), rec.Body.String(), rec.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestHandlerStreamsUnstoredResponses(t *testing.T) {
+	var calls atomic.Int32
+	rec := httptest.NewRecorder()
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		if !rec.Flushed || rec.Body.String() != "data: 1\n\n" {
+			t.Fatalf("event not sent before the handler returned: flushed = %v, body = %q", rec.Flushed, rec.Body.String())
+		}
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Header().Get(XCache) != cacheMiss || rec.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("X-Cache = %q, body = %q", rec.Header().Get(XCache), rec.Body.String())
+	}
+}
+
+func TestHandlerStaleResponses(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, "v1")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/stale", nil))
+	if rec := serve(h, httptest.NewRequest(http.MethodGet, "/stale", nil)); rec.Header().Get(XCache) != cacheMiss {
+		t.Fatalf("stale entry served without max-stale: X-Cache = %q", rec.Header().Get(XCache))
+	}
+	r := httptest.NewRequest(http.MethodGet, "/stale", nil)
+	r.Header.Set("Cache-Control", "max-stale")
+	if rec := serve(h, r); rec.Header().Get(XCache) != cacheHit || rec.Body.String() != "v1" {
+		t.Fatalf("max-stale: X-Cache = %q, body = %q", rec.Header().Get(XCache), rec.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("origin called %d times, want 2", calls.Load())
+	}
+}
+
+func TestHandlerVary(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+	get := func(lang string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/vary", nil)
+		r.Header.Set("Accept-Language", lang)
+		return serve(h, r)
+	}
+	get("en")
+	if rec := get("de"); rec.Header().Get(XCache) != cacheMiss || rec.Body.String() != "de" {
+		t.Fatalf("other language: X-Cache = %q, body = %q", rec.Header().Get(XCache), rec.Body.String())
+	}
+	if rec := get("de"); rec.Header().Get(XCache) != cacheHit || rec.Body.String() != "de" {
+		t.Fatalf("same language: X-Cache = %q, body = %q", rec.Header().Get(XCache), rec.Body.String())
+	}
+}
+
+func TestHandlerNotModified(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"abc"`)
+		io.WriteString(w, "body")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/etag", nil))
+	r := httptest.NewRequest(http.MethodGet, "/etag", nil)
+	r.Header.Set("If-None-Match", `W/"abc"`)
+	rec := serve(h, r)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("status = %d, body = %q, want 304 without body", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandlerInvalidatesAfterUnsafeRequests(t *testing.T) {
+	var calls atomic.Int32
+	h := NewHandler(newCache(t), origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "item")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/item", nil))
+	serve(h, httptest.NewRequest(http.MethodPost, "/item", nil))
+	if rec := serve(h, httptest.NewRequest(http.MethodGet, "/item", nil)); rec.Header().Get(XCache) != cacheMiss {
+		t.Fatalf("X-Cache = %q after POST, want MISS", rec.Header().Get(XCache))
+	}
+}
+
+func TestTransportRevalidates(t *testing.T) {
+	var calls, conditional atomic.Int32
+	srv := httptest.NewServer(origin(&calls, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "v1")
+	}))
+	defer srv.Close()
+	client := NewTransport(newCache(t)).Client()
+	get := func() (*http.Response, string) {
+		resp, err := client.Get(srv.URL + "/r")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	get()
+	resp, body := get()
+	if resp.Header.Get(XCache) != cacheRevalidated || body != "v1" || conditional.Load() != 1 {
+		t.Fatalf("X-Cache = %q, body = %q, conditional requests = %d", resp.Header.Get(XCache), body, conditional.Load())
+	}
+}
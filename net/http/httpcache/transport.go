@@ -0,0 +1,182 @@
+package httpcache
+
+import (
+	gbytes "bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	cacheutils "github.com/sudosz/go-utils/cache"
+)
+
+// Transport is an http.RoundTripper that serves responses from a cacheutils.Cache,
+// revalidating stale entries with ETag and Last-Modified conditional requests.
+// It acts as a private cache unless Shared is set.
+type Transport struct {
+	// Transport performs the requests that cannot be served from the cache; nil means http.DefaultTransport.
+	Transport http.RoundTripper
+	Cache     *cacheutils.Cache
+	// Shared applies shared-cache rules: s-maxage is honored, private and authorized responses are not stored.
+	Shared bool
+	// StaleTTL is how long entries are kept past their freshness for revalidation; zero means DefaultStaleTTL.
+	StaleTTL time.Duration
+	// OnError receives cache write errors, which never fail the request.
+	OnError func(error)
+}
+
+// NewTransport creates a private caching Transport on top of http.DefaultTransport.
+// Optimization: Lazily falls back to the default transport, no extra allocations.
+func NewTransport(c *cacheutils.Cache) *Transport {
+	return &Transport{Cache: c}
+}
+
+// Client returns an http.Client using the Transport.
+// Optimization: Single allocation.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// RoundTrip serves GET and HEAD requests from the cache when fresh, revalidates stale entries,
+// stores cacheable responses and invalidates entries after successful unsafe requests.
+// Responses carry an X-Cache header set to HIT, MISS or REVALIDATED.
+// Optimization: Fresh hits never touch the network; bodies of stored responses are buffered once.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.transport().RoundTrip(req)
+		if err == nil && isUnsafe(req.Method) && resp.StatusCode < http.StatusBadRequest {
+			invalidate(t.Cache, req, resp.Header)
+		}
+		return resp, err
+	}
+	if req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.transport().RoundTrip(req)
+	}
+	e, ok := load(t.Cache, req)
+	if ok && e.freshness(req, t.Shared, time.Now()) == stateFresh {
+		return e.response(req, cacheHit), nil
+	}
+	if parseCacheControl(req.Header).has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+	if ok && e.hasValidators() {
+		return t.revalidate(req, e)
+	}
+	return t.fetch(req)
+}
+
+// fetch performs the request and stores the response if allowed.
+func (t *Transport) fetch(req *http.Request) (*http.Response, error) {
+	requestTime := time.Now()
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.handle(req, resp, requestTime)
+}
+
+// handle marks a response from the origin as a miss and stores it if allowed.
+func (t *Transport) handle(req *http.Request, resp *http.Response, requestTime time.Time) (*http.Response, error) {
+	resp.Header.Set(XCache, cacheMiss)
+	if req.Method != http.MethodGet || !storable(req, resp.StatusCode, resp.Header, t.Shared) {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(gbytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	e := &entry{
+		status:       resp.StatusCode,
+		header:       resp.Header.Clone(),
+		body:         body,
+		requestTime:  requestTime,
+		responseTime: time.Now(),
+		varied:       http.Header{},
+	}
+	e.header.Del(XCache)
+	t.store(req, e)
+	return resp, nil
+}
+
+// revalidate sends a conditional request for a stale entry and serves it again on 304 Not Modified.
+func (t *Transport) revalidate(req *http.Request, e *entry) (*http.Response, error) {
+	creq := req.Clone(req.Context())
+	if etag := e.header.Get("ETag"); etag != "" {
+		creq.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.header.Get("Last-Modified"); lm != "" {
+		creq.Header.Set("If-Modified-Since", lm)
+	}
+	requestTime := time.Now()
+	resp, err := t.transport().RoundTrip(creq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return t.handle(req, resp, requestTime)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	e.updateHeaders(resp.Header)
+	e.requestTime, e.responseTime = requestTime, time.Now()
+	t.store(req, e)
+	return e.response(req, cacheRevalidated), nil
+}
+
+// store writes the entry, reporting failures to OnError.
+func (t *Transport) store(req *http.Request, e *entry) {
+	staleTTL := t.StaleTTL
+	if staleTTL <= 0 {
+		staleTTL = DefaultStaleTTL
+	}
+	if err := store(t.Cache, req, e, t.Shared, staleTTL); err != nil && t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// transport returns the underlying round tripper.
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// response builds an http.Response for the request from the stored entry.
+func (e *entry) response(req *http.Request, status string) *http.Response {
+	header := e.header.Clone()
+	e.setAge(header, time.Now())
+	header.Set(XCache, status)
+	body := e.body
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(gbytes.NewReader(body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// gatewayTimeout is the response to only-if-cached requests that cannot be served from the cache.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(http.StatusGatewayTimeout) + " " + http.StatusText(http.StatusGatewayTimeout),
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{XCache: {cacheMiss}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
@@ -187,7 +187,7 @@ func IsHTTPOKConn(r io.Reader, isConnect bool) (bool, error) {
 }
 
 // RawConnectRequestBytes builds a raw CONNECT request with optional authentication.
-// Optimization: Uses pooled buffer for minimal allocations.
+// Optimization: Builds in a pooled buffer and copies the result out once.
 func RawConnectRequestBytes(address string, proxyAuth func() string) []byte {
 	buf := bufPool.Get().(*gbytes.Buffer)
 	defer func() {
@@ -206,7 +206,7 @@ func RawConnectRequestBytes(address string, proxyAuth func() string) []byte {
 		buf.WriteString(crlf)
 	}
 	buf.WriteString(crlf)
-	return buf.Bytes()
+	return gbytes.Clone(buf.Bytes())
 }
 
 const (
@@ -229,7 +229,7 @@ var copyBufPool = &poolutils.LimitedPool{
 	N: 1 << 6,
 }
 
-// CopyBody copies data from src to dst, flushing if possible.
+// CopyBody copies data from src to dst, flushing dst after each write if possible.
 // Optimization: Uses pooled buffer and atomic counter for efficiency.
 func CopyBody(dst io.Writer, src io.Reader) (int64, error) {
 	buf := copyBufPool.Get().(*[copyBufSize]byte)
@@ -241,7 +241,7 @@ func CopyBody(dst io.Writer, src io.Reader) (int64, error) {
 			nw, err := dst.Write(buf[:nr])
 			written.Add(uint32(nw))
 			if nw > 0 {
-				Flush(src)
+				Flush(dst)
 			}
 			if err != nil {
 				return 0, err
@@ -279,7 +279,7 @@ func CopyHTTPHeaders(dst, src http.Header) {
 }
 
 // BuildRequestBytes constructs raw bytes for an HTTP request.
-// Optimization: Uses pooled buffer to minimize allocations.
+// Optimization: Builds in a pooled buffer and copies the result out once.
 func BuildRequestBytes(req *http.Request) (_ []byte, err error) {
 	buf := bufPool.Get().(*gbytes.Buffer)
 	defer func() {
@@ -324,7 +324,7 @@ func BuildRequestBytes(req *http.Request) (_ []byte, err error) {
 		}
 		req.Body.Close()
 	}
-	return buf.Bytes(), nil
+	return gbytes.Clone(buf.Bytes()), nil
 }
 
 // IsConnClosedErr checks if the error indicates a closed connection.
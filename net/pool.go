@@ -0,0 +1,345 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	iterutils "github.com/sudosz/go-utils/iter"
+)
+
+var ErrNoHealthyProxy = errors.New("no healthy proxy available")
+
+const (
+	DefaultMaxFailures   = 3
+	DefaultBaseCooldown  = 30 * time.Second
+	DefaultMaxCooldown   = 30 * time.Minute
+	DefaultStickyTTL     = 10 * time.Minute
+	DefaultProbeTimeout  = 5 * time.Second
+	DefaultProbeInterval = 15 * time.Second
+)
+
+// stickySweepMin is the session count below which expired sessions are not swept.
+const stickySweepMin = 64
+
+// stickyKey is the context key carrying a sticky session key.
+type stickyKey struct{}
+
+// WithStickyKey returns a context making ProxyPool.DialContext reuse the proxy of the session key.
+// Optimization: A single context value, no allocation beyond the context node.
+func WithStickyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, stickyKey{}, key)
+}
+
+// poolEntry is a proxy of the pool with its health state.
+type poolEntry struct {
+	proxy     *Proxy
+	dialer    ContextDialer
+	failures  int
+	ejections int
+	until     time.Time
+}
+
+// stickySession binds a session key to an entry until it expires.
+type stickySession struct {
+	entry   *poolEntry
+	expires time.Time
+}
+
+// ProxyPool rotates through proxies, ejecting those failing MaxFailures times in a row
+// for a cooldown that doubles with every consecutive ejection, up to MaxCooldown.
+// Proxies past their cooldown return to rotation; with StartProbing they are probed first.
+// It implements ContextDialer, reporting dial outcomes itself.
+type ProxyPool struct {
+	// MaxFailures is the number of consecutive failures ejecting a proxy; zero means DefaultMaxFailures.
+	MaxFailures int
+	// BaseCooldown is the first ejection's duration; zero means DefaultBaseCooldown.
+	BaseCooldown time.Duration
+	// MaxCooldown caps the ejection duration; zero means DefaultMaxCooldown.
+	MaxCooldown time.Duration
+	// StickyTTL is how long a session keeps its proxy after last use; zero means DefaultStickyTTL.
+	StickyTTL time.Duration
+	// Probe checks an ejected proxy; nil dials its address within DefaultProbeTimeout.
+	Probe func(ctx context.Context, p *Proxy) error
+
+	it      *iterutils.CycleIterator[*poolEntry]
+	mux     sync.Mutex
+	entries map[*Proxy]*poolEntry
+	sticky  map[string]stickySession
+	sweepAt int
+}
+
+// NewProxyPool creates a pool rotating through the given proxies.
+// Optimization: Dialers are built once per proxy when added.
+func NewProxyPool(proxies ...*Proxy) (*ProxyPool, error) {
+	pp := &ProxyPool{
+		it:      iterutils.NewCycleIterator[*poolEntry](),
+		entries: make(map[*Proxy]*poolEntry),
+		sticky:  make(map[string]stickySession),
+	}
+	return pp, pp.Add(proxies...)
+}
+
+// Add appends proxies to the rotation; proxies already in the pool are ignored.
+// Optimization: Validates and builds every dialer before taking the lock.
+func (pp *ProxyPool) Add(proxies ...*Proxy) error {
+	added := make([]*poolEntry, 0, len(proxies))
+	for _, p := range proxies {
+		d, err := p.Dialer()
+		if err != nil {
+			return err
+		}
+		added = append(added, &poolEntry{proxy: p, dialer: d})
+	}
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	for _, e := range added {
+		if _, ok := pp.entries[e.proxy]; ok {
+			continue
+		}
+		pp.entries[e.proxy] = e
+		pp.it.Add(e)
+	}
+	return nil
+}
+
+// Len returns the number of proxies in the pool, healthy or not.
+// Optimization: Delegates to the iterator's read-locked length.
+func (pp *ProxyPool) Len() int {
+	return pp.it.Len()
+}
+
+// Healthy returns the proxies currently in rotation.
+// Optimization: A single pass under the lock.
+func (pp *ProxyPool) Healthy() []*Proxy {
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	now := time.Now()
+	healthy := make([]*Proxy, 0, len(pp.entries))
+	for p, e := range pp.entries {
+		if !now.Before(e.until) {
+			healthy = append(healthy, p)
+		}
+	}
+	return healthy
+}
+
+// Next returns the next proxy in rotation, skipping ejected ones, or ErrNoHealthyProxy.
+// Optimization: At most one full turn of the iterator.
+func (pp *ProxyPool) Next() (*Proxy, error) {
+	e, err := pp.next()
+	if err != nil {
+		return nil, err
+	}
+	return e.proxy, nil
+}
+
+// Sticky returns the proxy bound to key, binding the next healthy proxy when the session
+// is new, expired or its proxy was ejected. Each call extends the session by StickyTTL.
+// Optimization: Session lookups are a single map access under the lock.
+func (pp *ProxyPool) Sticky(key string) (*Proxy, error) {
+	e, err := pp.stickyEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.proxy, nil
+}
+
+// ReportSuccess marks a proxy as working, resetting its failures and cooldown.
+// Optimization: Constant time under the lock.
+func (pp *ProxyPool) ReportSuccess(p *Proxy) {
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	if e, ok := pp.entries[p]; ok {
+		e.failures, e.ejections, e.until = 0, 0, time.Time{}
+	}
+}
+
+// ReportFailure counts a failure of a proxy, ejecting it once MaxFailures is reached.
+// Optimization: Constant time under the lock.
+func (pp *ProxyPool) ReportFailure(p *Proxy) {
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	if e, ok := pp.entries[p]; ok {
+		e.failures++
+		if e.failures >= pp.maxFailures() {
+			pp.eject(e)
+		}
+	}
+}
+
+// DialContext dials addr through the next proxy, or the sticky proxy of a key set with WithStickyKey.
+// Failures reaching the proxy count against it; refusals to reach the target and local
+// resolution errors do not.
+// Optimization: The proxy's dialer is built once when the proxy is added.
+func (pp *ProxyPool) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var (
+		e   *poolEntry
+		err error
+	)
+	if key, ok := ctx.Value(stickyKey{}).(string); ok {
+		e, err = pp.stickyEntry(key)
+	} else {
+		e, err = pp.next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, err := e.dialer.DialContext(ctx, network, addr)
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil, errors.Is(err, ErrSocksRequestFailed), errors.Is(err, ErrProxyConnectFailed):
+		pp.ReportSuccess(e.proxy)
+	case ctx.Err() == nil && !errors.As(err, &dnsErr):
+		pp.ReportFailure(e.proxy)
+	}
+	return conn, err
+}
+
+// StartProbing probes proxies past their cooldown every interval, returning them to rotation
+// when the probe succeeds and ejecting them again otherwise. It returns a function stopping the prober.
+// Optimization: Only ejected proxies are probed, each in its own goroutine.
+func (pp *ProxyPool) StartProbing(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pp.probe(ctx)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// probe checks every ejected proxy whose cooldown has passed.
+func (pp *ProxyPool) probe(ctx context.Context) {
+	now := time.Now()
+	var due []*poolEntry
+	pp.mux.Lock()
+	for _, e := range pp.entries {
+		if e.ejections > 0 && !now.Before(e.until) {
+			// hold the proxy out of rotation while it is probed
+			e.until = now.Add(DefaultProbeTimeout)
+			due = append(due, e)
+		}
+	}
+	pp.mux.Unlock()
+	var wg sync.WaitGroup
+	for _, e := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pp.probeOne(ctx, e.proxy); err != nil {
+				if ctx.Err() == nil {
+					pp.mux.Lock()
+					pp.eject(e)
+					pp.mux.Unlock()
+				}
+				return
+			}
+			pp.ReportSuccess(e.proxy)
+		}()
+	}
+	wg.Wait()
+}
+
+// probeOne runs the configured probe, or dials the proxy's address.
+func (pp *ProxyPool) probeOne(ctx context.Context, p *Proxy) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultProbeTimeout)
+	defer cancel()
+	if pp.Probe != nil {
+		return pp.Probe(ctx, p)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// next returns the next entry out of cooldown.
+func (pp *ProxyPool) next() (*poolEntry, error) {
+	n := pp.it.Len()
+	now := time.Now()
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	for i := 0; i < n; i++ {
+		if e := pp.it.Get(); e != nil && !now.Before(e.until) {
+			return e, nil
+		}
+	}
+	return nil, ErrNoHealthyProxy
+}
+
+// stickyEntry returns the entry of the session key, rebinding it when needed.
+func (pp *ProxyPool) stickyEntry(key string) (*poolEntry, error) {
+	now := time.Now()
+	pp.mux.Lock()
+	s, ok := pp.sticky[key]
+	pp.mux.Unlock()
+	if !ok || now.After(s.expires) || now.Before(s.entry.until) {
+		e, err := pp.next()
+		if err != nil {
+			return nil, err
+		}
+		s.entry = e
+	}
+	ttl := pp.StickyTTL
+	if ttl <= 0 {
+		ttl = DefaultStickyTTL
+	}
+	s.expires = now.Add(ttl)
+	pp.mux.Lock()
+	pp.sticky[key] = s
+	if len(pp.sticky) >= pp.sweepAt {
+		for k, other := range pp.sticky {
+			if now.After(other.expires) {
+				delete(pp.sticky, k)
+			}
+		}
+		pp.sweepAt = 2*len(pp.sticky) + stickySweepMin
+	}
+	pp.mux.Unlock()
+	return s.entry, nil
+}
+
+// eject takes the entry out of rotation for an exponentially growing cooldown; the caller holds the lock.
+func (pp *ProxyPool) eject(e *poolEntry) {
+	base, max := pp.BaseCooldown, pp.MaxCooldown
+	if base <= 0 {
+		base = DefaultBaseCooldown
+	}
+	if max <= 0 {
+		max = DefaultMaxCooldown
+	}
+	cooldown := base
+	for i := 0; i < e.ejections && cooldown < max; i++ {
+		cooldown *= 2
+	}
+	e.ejections++
+	e.failures = 0
+	e.until = time.Now().Add(min(cooldown, max))
+}
+
+// maxFailures returns the effective failure threshold.
+func (pp *ProxyPool) maxFailures() int {
+	if pp.MaxFailures > 0 {
+		return pp.MaxFailures
+	}
+	return DefaultMaxFailures
+}
@@ -0,0 +1,456 @@
+package netutils
+
+import (
+	"bufio"
+	gbytes "bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoProxyHeader      = errors.New("no proxy protocol header")
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	// DefaultProxyHeaderTimeout bounds reading a PROXY protocol header.
+	DefaultProxyHeaderTimeout = 5 * time.Second
+
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLen    = 107
+	proxyV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+	proxyV2HeaderLen = 16
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamUnspec = 0x00
+	proxyV2FamTCP4   = 0x11
+	proxyV2FamUDP4   = 0x12
+	proxyV2FamTCP6   = 0x21
+	proxyV2FamUDP6   = 0x22
+)
+
+// ProxyTLV is a type-length-value extension of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is a PROXY protocol header describing the original connection.
+// Nil addresses encode as UNKNOWN in v1 and as a LOCAL command in v2.
+type ProxyHeader struct {
+	// Version is 1 for the text format or 2 for the binary format.
+	Version int
+	// Source and Destination are *net.TCPAddr or *net.UDPAddr.
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are v2 extensions; they are not written in v1.
+	TLVs []ProxyTLV
+}
+
+// ReadProxyHeader reads a v1 or v2 header, returning ErrNoProxyHeader without consuming
+// anything when the stream does not start with one.
+// Optimization: Detects the format by peeking only as many bytes as needed.
+func ReadProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	switch {
+	case peekPrefix(br, proxyV1Prefix):
+		return readProxyV1(br)
+	case peekPrefix(br, proxyV2Signature):
+		return readProxyV2(br)
+	default:
+		return nil, ErrNoProxyHeader
+	}
+}
+
+// peekPrefix reports whether the buffered stream starts with prefix, reading no further than the first mismatch.
+func peekPrefix(br *bufio.Reader, prefix string) bool {
+	for i := range len(prefix) {
+		b, err := br.Peek(i + 1)
+		if err != nil || b[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+func readProxyV1(br *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !gbytes.HasSuffix(line, []byte(crlf)) {
+		return nil, ErrInvalidProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	src, err1 := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	dst, err2 := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err1 != nil || err2 != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+// parseProxyV1Addr parses an address and port of the family given by v4.
+func parseProxyV1Addr(ip, port string, v4 bool) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil || (addr.To4() != nil) != v4 {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// readProxyV2 parses the binary header.
+func readProxyV2(br *bufio.Reader) (*ProxyHeader, error) {
+	var hdr [proxyV2HeaderLen]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	h := &ProxyHeader{Version: 2}
+	cmd, fam := hdr[12]&0xf, hdr[13]
+	var n int
+	switch fam {
+	case proxyV2FamTCP4, proxyV2FamUDP4:
+		n = net.IPv4len
+	case proxyV2FamTCP6, proxyV2FamUDP6:
+		n = net.IPv6len
+	}
+	if n > 0 {
+		if len(body) < 2*n+4 {
+			return nil, ErrInvalidProxyHeader
+		}
+		srcIP := net.IP(gbytes.Clone(body[:n]))
+		dstIP := net.IP(gbytes.Clone(body[n : 2*n]))
+		srcPort := int(binary.BigEndian.Uint16(body[2*n:]))
+		dstPort := int(binary.BigEndian.Uint16(body[2*n+2:]))
+		if fam&0xf == 0x2 {
+			h.Source, h.Destination = &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		} else {
+			h.Source, h.Destination = &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+		}
+		body = body[2*n+4:]
+	} else if fam != proxyV2FamUnspec {
+		// unix and other families carry no usable address; skip them
+		body = nil
+	}
+	switch cmd {
+	case proxyV2CmdLocal:
+		h.Source, h.Destination = nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	for len(body) > 0 {
+		if len(body) < 3 {
+			return nil, ErrInvalidProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(body[1:]))
+		if len(body) < 3+l {
+			return nil, ErrInvalidProxyHeader
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: body[0], Value: body[3 : 3+l]})
+		body = body[3+l:]
+	}
+	return h, nil
+}
+
+// AppendTo encodes the header in its Version's format, v1 when Version is not 2.
+// Optimization: Encodes into the caller's buffer.
+func (h *ProxyHeader) AppendTo(b []byte) ([]byte, error) {
+	src, sport, sok := splitProxyAddr(h.Source)
+	dst, dport, dok := splitProxyAddr(h.Destination)
+	known := sok && dok && (src.To4() != nil) == (dst.To4() != nil)
+	if h.Version != 2 {
+		if !known {
+			return append(b, "PROXY UNKNOWN\r\n"...), nil
+		}
+		family := "TCP6"
+		if src.To4() != nil {
+			family = "TCP4"
+		}
+		return fmt.Appendf(b, "PROXY %s %s %s %d %d\r\n", family, src, dst, sport, dport), nil
+	}
+	b = append(b, proxyV2Signature...)
+	var body []byte
+	if !known {
+		b = append(b, 0x20|proxyV2CmdLocal, proxyV2FamUnspec)
+	} else {
+		fam := byte(proxyV2FamTCP6)
+		if src.To4() != nil {
+			fam = proxyV2FamTCP4
+			src, dst = src.To4(), dst.To4()
+		} else {
+			src, dst = src.To16(), dst.To16()
+		}
+		if _, udp := h.Source.(*net.UDPAddr); udp {
+			fam++
+		}
+		b = append(b, 0x20|proxyV2CmdProxy, fam)
+		body = append(append(body, src...), dst...)
+		body = binary.BigEndian.AppendUint16(body, uint16(sport))
+		body = binary.BigEndian.AppendUint16(body, uint16(dport))
+	}
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xffff {
+			return nil, ErrInvalidProxyHeader
+		}
+		body = append(body, tlv.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+	if len(body) > 0xffff {
+		return nil, ErrInvalidProxyHeader
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...), nil
+}
+
+// splitProxyAddr extracts the IP and port of a TCP or UDP address.
+func splitProxyAddr(addr net.Addr) (net.IP, int, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a != nil {
+			return a.IP, a.Port, a.IP != nil
+		}
+	case *net.UDPAddr:
+		if a != nil {
+			return a.IP, a.Port, a.IP != nil
+		}
+	}
+	return nil, 0, false
+}
+
+// ProxyProtoListener wraps a listener whose peers, typically load balancers, send a PROXY
+// protocol header; accepted connections report the original client as RemoteAddr.
+type ProxyProtoListener struct {
+	net.Listener
+	// HeaderTimeout bounds reading the header; zero means DefaultProxyHeaderTimeout.
+	HeaderTimeout time.Duration
+	// Required rejects connections without a header; otherwise they keep their own addresses.
+	Required bool
+	// Trusted reports whether a peer may send a header; nil trusts no peer.
+	// Headers from untrusted peers are not parsed and reach the application as data.
+	Trusted func(peer net.Addr) bool
+}
+
+// NewProxyProtoListener wraps ln, accepting connections with an optional PROXY header from
+// peers that trusted accepts, typically TrustedPrefixes of the load balancers. A trusted peer
+// can claim any client address, so trusting every peer lets any client spoof RemoteAddr.
+// Optimization: Headers are parsed lazily so Accept never blocks on a slow peer.
+func NewProxyProtoListener(ln net.Listener, trusted func(peer net.Addr) bool) *ProxyProtoListener {
+	return &ProxyProtoListener{Listener: ln, Trusted: trusted}
+}
+
+// TrustedPrefixes returns a trust policy for ProxyProtoListener accepting peers within the prefixes.
+// Optimization: Addresses are compared as netip values without allocation.
+func TrustedPrefixes(prefixes ...netip.Prefix) func(peer net.Addr) bool {
+	return func(peer net.Addr) bool {
+		ip, _, ok := splitProxyAddr(peer)
+		if !ok {
+			return false
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, p := range prefixes {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Accept returns the next connection wrapped as a *ProxyProtoConn.
+// Optimization: Untrusted peers are returned unwrapped.
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted == nil || !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &ProxyProtoConn{Conn: conn, br: bufio.NewReader(conn), timeout: timeout, required: l.Required}, nil
+}
+
+// ProxyProtoConn is a connection that may start with a PROXY protocol header.
+// The header is read on the first Read, RemoteAddr, LocalAddr or Header call.
+type ProxyProtoConn struct {
+	net.Conn
+	br       *bufio.Reader
+	timeout  time.Duration
+	required bool
+	once     sync.Once
+	header   *ProxyHeader
+	err      error
+	mux      sync.Mutex
+	deadline time.Time
+}
+
+// Header returns the parsed header, or nil when the connection had none.
+// Optimization: Parsed once and cached.
+func (c *ProxyProtoConn) Header() (*ProxyHeader, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+// Read reads application data following the header.
+// Optimization: Buffered data left from header parsing is drained first.
+func (c *ProxyProtoConn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	if c.br.Buffered() > 0 {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the original client address from the header, or the peer's address.
+// Optimization: Cached after the first call.
+func (c *ProxyProtoConn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address from the header, or the local address.
+// Optimization: Cached after the first call.
+func (c *ProxyProtoConn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines; the read deadline also bounds reading the header.
+// Optimization: Direct passthrough, remembering the read deadline.
+func (c *ProxyProtoConn) SetDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, which also bounds reading the header.
+// Optimization: Direct passthrough, remembering the deadline.
+func (c *ProxyProtoConn) SetReadDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readHeader parses the header within the timeout or the caller's earlier read deadline,
+// then restores the caller's deadline.
+func (c *ProxyProtoConn) readHeader() {
+	c.mux.Lock()
+	deadline := time.Now().Add(c.timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	c.Conn.SetReadDeadline(deadline)
+	c.mux.Unlock()
+	defer func() {
+		c.mux.Lock()
+		c.Conn.SetReadDeadline(c.deadline)
+		c.mux.Unlock()
+	}()
+	c.header, c.err = ReadProxyHeader(c.br)
+	if errors.Is(c.err, ErrNoProxyHeader) && !c.required {
+		c.err = nil
+	}
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+// proxyHeaderKey is the context key carrying the header for ProxyProtoDialer.
+type proxyHeaderKey struct{}
+
+// WithProxyHeader returns a context making ProxyProtoDialer send h, e.g. the addresses of
+// the client being forwarded.
+// Optimization: A single context value.
+func WithProxyHeader(ctx context.Context, h *ProxyHeader) context.Context {
+	return context.WithValue(ctx, proxyHeaderKey{}, h)
+}
+
+// ProxyProtoDialer writes a PROXY protocol header on every connection it dials. The header
+// comes from the context (see WithProxyHeader) or else describes the dialed connection itself.
+type ProxyProtoDialer struct {
+	// Dialer makes the connections; nil means a zero net.Dialer.
+	Dialer ContextDialer
+	// Version is 1 or 2.
+	Version int
+}
+
+// NewProxyProtoDialer wraps d so its connections start with a PROXY header of the given version.
+// Optimization: Single allocation.
+func NewProxyProtoDialer(d ContextDialer, version int) *ProxyProtoDialer {
+	return &ProxyProtoDialer{Dialer: d, Version: version}
+}
+
+// DialContext dials addr and writes the header before returning the connection.
+// Optimization: The header is encoded into a single write.
+func (d *ProxyProtoDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := dialer(d.Dialer).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := ctx.Value(proxyHeaderKey{}).(*ProxyHeader)
+	if h == nil {
+		h = &ProxyHeader{Source: conn.LocalAddr(), Destination: conn.RemoteAddr()}
+	}
+	hv := *h
+	hv.Version = d.Version
+	b, err := hv.AppendTo(nil)
+	if err == nil {
+		_, err = conn.Write(b)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,281 @@
+package netutils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyV1(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		src, dst string
+		err      error
+	}{
+		{"tcp4", "PROXY TCP4 192.0.2.1 198.51.100.2 56324 443\r\n", "192.0.2.1:56324", "198.51.100.2:443", nil},
+		{"tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n", "[2001:db8::1]:1", "[2001:db8::2]:2", nil},
+		{"unknown", "PROXY UNKNOWN\r\n", "", "", nil},
+		{"unknown with addresses", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", "", nil},
+		{"missing cr", "PROXY TCP4 192.0.2.1 198.51.100.2 1 2\n", "", "", ErrInvalidProxyHeader},
+		{"family mismatch", "PROXY TCP4 2001:db8::1 198.51.100.2 1 2\r\n", "", "", ErrInvalidProxyHeader},
+		{"bad port", "PROXY TCP4 192.0.2.1 198.51.100.2 65536 2\r\n", "", "", ErrInvalidProxyHeader},
+		{"missing field", "PROXY TCP4 192.0.2.1 198.51.100.2 1\r\n", "", "", ErrInvalidProxyHeader},
+		{"bad protocol", "PROXY UDP4 192.0.2.1 198.51.100.2 1 2\r\n", "", "", ErrInvalidProxyHeader},
+		{"too long", "PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n", "", "", ErrInvalidProxyHeader},
+		{"truncated", "PROXY TCP4 192.0.2.1", "", "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(tt.in)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if h.Version != 1 || addrString(h.Source) != tt.src || addrString(h.Destination) != tt.dst {
+				t.Fatalf("got v%d %s -> %s, want v1 %s -> %s", h.Version, addrString(h.Source), addrString(h.Destination), tt.src, tt.dst)
+			}
+		})
+	}
+}
+
+func TestReadProxyV2(t *testing.T) {
+	v2 := func(verCmd, fam byte, body ...byte) string {
+		b := append([]byte(proxyV2Signature), verCmd, fam, byte(len(body)>>8), byte(len(body)))
+		return string(append(b, body...))
+	}
+	tcp4 := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0xdc, 0x04, 0x01, 0xbb}
+	tests := []struct {
+		name     string
+		in       string
+		src, dst string
+		udp      bool
+		tlvs     []ProxyTLV
+		err      error
+	}{
+		{"tcp4", v2(0x21, proxyV2FamTCP4, tcp4...), "192.0.2.1:56324", "198.51.100.2:443", false, nil, nil},
+		{"udp4", v2(0x21, proxyV2FamUDP4, tcp4...), "192.0.2.1:56324", "198.51.100.2:443", true, nil, nil},
+		{"tlv", v2(0x21, proxyV2FamTCP4, append(tcp4, 0x01, 0x00, 0x02, 'h', '2')...), "192.0.2.1:56324", "198.51.100.2:443", false,
+			[]ProxyTLV{{Type: 0x01, Value: []byte("h2")}}, nil},
+		{"local", v2(0x20, proxyV2FamTCP4, tcp4...), "", "", false, nil, nil},
+		{"unspec", v2(0x21, proxyV2FamUnspec), "", "", false, nil, nil},
+		{"unix skipped", v2(0x21, 0x31, make([]byte, 216)...), "", "", false, nil, nil},
+		{"bad version", v2(0x11, proxyV2FamTCP4, tcp4...), "", "", false, nil, ErrInvalidProxyHeader},
+		{"bad command", v2(0x22, proxyV2FamTCP4, tcp4...), "", "", false, nil, ErrInvalidProxyHeader},
+		{"short addresses", v2(0x21, proxyV2FamTCP6, tcp4...), "", "", false, nil, ErrInvalidProxyHeader},
+		{"short tlv", v2(0x21, proxyV2FamTCP4, append(tcp4, 0x01, 0x00)...), "", "", false, nil, ErrInvalidProxyHeader},
+		{"tlv past body", v2(0x21, proxyV2FamTCP4, append(tcp4, 0x01, 0x00, 0x05, 'x')...), "", "", false, nil, ErrInvalidProxyHeader},
+		{"truncated body", v2(0x21, proxyV2FamTCP4, tcp4...)[:proxyV2HeaderLen+4], "", "", false, nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(tt.in)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if h.Version != 2 || addrString(h.Source) != tt.src || addrString(h.Destination) != tt.dst {
+				t.Fatalf("got v%d %s -> %s, want v2 %s -> %s", h.Version, addrString(h.Source), addrString(h.Destination), tt.src, tt.dst)
+			}
+			if _, udp := h.Source.(*net.UDPAddr); tt.src != "" && udp != tt.udp {
+				t.Fatalf("source %T, want UDP %v", h.Source, tt.udp)
+			}
+			if !reflect.DeepEqual(h.TLVs, tt.tlvs) {
+				t.Fatalf("TLVs = %v, want %v", h.TLVs, tt.tlvs)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderWithoutHeader(t *testing.T) {
+	for _, in := range []string{"GET / HTTP/1.1\r\n", "PROX", "\r\n\r\nhello", ""} {
+		br := bufio.NewReader(strings.NewReader(in))
+		if _, err := ReadProxyHeader(br); !errors.Is(err, ErrNoProxyHeader) {
+			t.Fatalf("%q: err = %v, want ErrNoProxyHeader", in, err)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != in {
+			t.Fatalf("%q: consumed input, %q left", in, rest)
+		}
+	}
+}
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	tcp := func(s string) net.Addr { return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s)) }
+	udp := func(s string) net.Addr { return net.UDPAddrFromAddrPort(netip.MustParseAddrPort(s)) }
+	tests := []struct {
+		name     string
+		h        ProxyHeader
+		src, dst string
+	}{
+		{"v1 tcp4", ProxyHeader{Version: 1, Source: tcp("192.0.2.1:1"), Destination: tcp("198.51.100.2:2")}, "192.0.2.1:1", "198.51.100.2:2"},
+		{"v1 tcp6", ProxyHeader{Version: 1, Source: tcp("[2001:db8::1]:1"), Destination: tcp("[2001:db8::2]:2")}, "[2001:db8::1]:1", "[2001:db8::2]:2"},
+		{"v1 unknown", ProxyHeader{Version: 1}, "", ""},
+		{"v1 mixed families", ProxyHeader{Version: 1, Source: tcp("192.0.2.1:1"), Destination: tcp("[2001:db8::2]:2")}, "", ""},
+		{"v2 tcp4", ProxyHeader{Version: 2, Source: tcp("192.0.2.1:1"), Destination: tcp("198.51.100.2:2")}, "192.0.2.1:1", "198.51.100.2:2"},
+		{"v2 tcp6", ProxyHeader{Version: 2, Source: tcp("[2001:db8::1]:1"), Destination: tcp("[2001:db8::2]:2")}, "[2001:db8::1]:1", "[2001:db8::2]:2"},
+		{"v2 udp4", ProxyHeader{Version: 2, Source: udp("192.0.2.1:1"), Destination: udp("198.51.100.2:2")}, "192.0.2.1:1", "198.51.100.2:2"},
+		{"v2 udp6", ProxyHeader{Version: 2, Source: udp("[2001:db8::1]:1"), Destination: udp("[2001:db8::2]:2")}, "[2001:db8::1]:1", "[2001:db8::2]:2"},
+		{"v2 local", ProxyHeader{Version: 2}, "", ""},
+		{"v2 tlvs", ProxyHeader{Version: 2, Source: tcp("192.0.2.1:1"), Destination: tcp("198.51.100.2:2"),
+			TLVs: []ProxyTLV{{Type: 0x01, Value: []byte("h2")}, {Type: 0x05, Value: []byte{}}}}, "192.0.2.1:1", "198.51.100.2:2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.h.AppendTo([]byte("prefix"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(b, []byte("prefix")) {
+				t.Fatalf("AppendTo overwrote the buffer: %q", b)
+			}
+			br := bufio.NewReader(bytes.NewReader(append(b[len("prefix"):], "data"...)))
+			h, err := ReadProxyHeader(br)
+			if err != nil {
+				t.Fatalf("ReadProxyHeader(%q): %v", b, err)
+			}
+			if h.Version != tt.h.Version || addrString(h.Source) != tt.src || addrString(h.Destination) != tt.dst {
+				t.Fatalf("got v%d %s -> %s, want v%d %s -> %s", h.Version, addrString(h.Source), addrString(h.Destination),
+					tt.h.Version, tt.src, tt.dst)
+			}
+			if tt.src != "" && reflect.TypeOf(h.Source) != reflect.TypeOf(tt.h.Source) {
+				t.Fatalf("source %T, want %T", h.Source, tt.h.Source)
+			}
+			if len(h.TLVs) != len(tt.h.TLVs) {
+				t.Fatalf("TLVs = %v, want %v", h.TLVs, tt.h.TLVs)
+			}
+			for i := range h.TLVs {
+				if h.TLVs[i].Type != tt.h.TLVs[i].Type || !bytes.Equal(h.TLVs[i].Value, tt.h.TLVs[i].Value) {
+					t.Fatalf("TLVs = %v, want %v", h.TLVs, tt.h.TLVs)
+				}
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "data" {
+				t.Fatalf("data after header = %q", rest)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderRejectsOversizedTLV(t *testing.T) {
+	h := ProxyHeader{Version: 2, TLVs: []ProxyTLV{{Type: 1, Value: make([]byte, 0x10000)}}}
+	if _, err := h.AppendTo(nil); !errors.Is(err, ErrInvalidProxyHeader) {
+		t.Fatalf("err = %v, want ErrInvalidProxyHeader", err)
+	}
+}
+
+func TestProxyProtoListenerTrust(t *testing.T) {
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.2 1 2\r\n"
+	tests := []struct {
+		name     string
+		trusted  func(net.Addr) bool
+		required bool
+		send     string
+		remote   string
+		data     string
+		err      bool
+	}{
+		{"nil trusts no peer", nil, false, header + "hi", "127.0.0.1", header + "hi", false},
+		{"untrusted prefix", TrustedPrefixes(netip.MustParsePrefix("10.0.0.0/8")), false, header + "hi", "127.0.0.1", header + "hi", false},
+		{"trusted prefix", TrustedPrefixes(netip.MustParsePrefix("127.0.0.0/8")), false, header + "hi", "192.0.2.1", "hi", false},
+		{"trusted without header", TrustedPrefixes(netip.MustParsePrefix("127.0.0.0/8")), false, "hi", "127.0.0.1", "hi", false},
+		{"required header missing", TrustedPrefixes(netip.MustParsePrefix("127.0.0.0/8")), true, "hi", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			pl := NewProxyProtoListener(ln, tt.trusted)
+			pl.Required = tt.required
+			go func() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				io.WriteString(c, tt.send)
+				c.(*net.TCPConn).CloseWrite()
+				io.Copy(io.Discard, c)
+			}()
+			conn, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, err := io.ReadAll(conn)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("read %q, want an error", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != tt.remote || string(data) != tt.data {
+				t.Fatalf("RemoteAddr = %s, data = %q, want %s and %q", conn.RemoteAddr(), data, tt.remote, tt.data)
+			}
+		})
+	}
+}
+
+func TestProxyProtoConnKeepsReadDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pl := NewProxyProtoListener(ln, func(net.Addr) bool { return true })
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, "PROXY TCP4 192.0.2.1 198.51.100.2 1 2\r\n")
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+		}
+	}()
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read err = %v, want the caller's deadline to expire", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Read returned after %v, past the caller's deadline", elapsed)
+	}
+	if conn.RemoteAddr().String() != "192.0.2.1:1" {
+		t.Fatalf("RemoteAddr = %s", conn.RemoteAddr())
+	}
+}
+
+// addrString renders an address, or "" for none.
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
@@ -0,0 +1,196 @@
+package netutils
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyAuthenticator reports whether a proxy request carries valid credentials.
+type ProxyAuthenticator func(r *http.Request) bool
+
+// ProxyServer is an HTTP forward proxy handling CONNECT tunnels and absolute-URI requests.
+// Targets are reached through Dialer, so setting it to a ProxyDialer, Socks5Dialer or ProxyPool
+// chains the server to upstream proxies; plain HTTP requests are then tunneled through them too.
+type ProxyServer struct {
+	// Dialer reaches targets; nil means a zero net.Dialer.
+	Dialer ContextDialer
+	// Authenticate checks the Proxy-Authorization of each request; nil accepts every request.
+	Authenticate ProxyAuthenticator
+	// Realm is announced in Proxy-Authenticate challenges.
+	Realm string
+	// OnRequest runs for every authenticated request, CONNECT included, and may modify it;
+	// returning a response answers the request with it instead of proxying.
+	OnRequest func(r *http.Request) *http.Response
+	// OnResponse runs for every forwarded response before it is written to the client.
+	OnResponse func(resp *http.Response)
+	// Transport forwards absolute-URI requests; nil means a transport dialing through Dialer.
+	Transport http.RoundTripper
+	// IdleTimeout closes CONNECT tunnels without traffic for that long; zero disables it.
+	IdleTimeout time.Duration
+
+	once      sync.Once
+	transport http.RoundTripper
+}
+
+// NewProxyServer creates a ProxyServer reaching targets through an optional dialer.
+// Optimization: The forwarding transport is built lazily on first use.
+func NewProxyServer(dialer ...ContextDialer) *ProxyServer {
+	s := &ProxyServer{}
+	if len(dialer) > 0 {
+		s.Dialer = dialer[0]
+	}
+	return s
+}
+
+// ListenAndServe serves the proxy on addr.
+// Optimization: Delegates to http.Server.
+func (s *ProxyServer) ListenAndServe(addr string) error {
+	return (&http.Server{Addr: addr, Handler: s}).ListenAndServe()
+}
+
+// ServeHTTP authenticates the request, then tunnels CONNECT requests and forwards the others.
+// Optimization: Tunnels copy directly between the hijacked connections, using splice when possible.
+func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Authenticate != nil && !s.Authenticate(r) {
+		realm := s.Realm
+		if realm == "" {
+			realm = "proxy"
+		}
+		w.Header().Set("Proxy-Authenticate", `Basic realm="`+realm+`"`)
+		http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+		return
+	}
+	r.Header.Del("Proxy-Authorization")
+	if s.OnRequest != nil {
+		if resp := s.OnRequest(r); resp != nil {
+			writeResponse(w, resp)
+			return
+		}
+	}
+	if r.Method == http.MethodConnect {
+		s.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "proxy requests must use an absolute URI", http.StatusBadRequest)
+		return
+	}
+	s.forward(w, r)
+}
+
+// connect dials the target and tunnels the client connection to it.
+func (s *ProxyServer) connect(w http.ResponseWriter, r *http.Request) {
+	target, err := dialer(s.Dialer).DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		target.Close()
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	client, rw, err := hj.Hijack()
+	if err != nil {
+		target.Close()
+		return
+	}
+	if _, err := client.Write(StatusOKBytes(1, 1)); err != nil {
+		client.Close()
+		target.Close()
+		return
+	}
+	if rw.Reader.Buffered() > 0 {
+		client = &bufferedConn{Conn: client, r: rw.Reader}
+	}
+	Tunnel(client, target, s.IdleTimeout)
+}
+
+// forward sends an absolute-URI request to its origin and relays the response.
+func (s *ProxyServer) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	delConnectionHeaders(out.Header)
+	DelHopHeaders(out.Header)
+	if r.ContentLength == 0 {
+		out.Body = nil
+	}
+	resp, err := s.roundTripper().RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	delConnectionHeaders(resp.Header)
+	DelHopHeaders(resp.Header)
+	if s.OnResponse != nil {
+		s.OnResponse(resp)
+	}
+	writeResponse(w, resp)
+}
+
+// roundTripper returns the configured transport or one dialing through Dialer.
+func (s *ProxyServer) roundTripper() http.RoundTripper {
+	if s.Transport != nil {
+		return s.Transport
+	}
+	s.once.Do(func() {
+		s.transport = &http.Transport{
+			DialContext:           dialer(s.Dialer).DialContext,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+		}
+	})
+	return s.transport
+}
+
+// writeResponse copies the status, headers and body of resp to w.
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	CopyHTTPHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	if resp.Body != nil {
+		CopyBody(w, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// delConnectionHeaders removes the headers listed in Connection, which are hop-by-hop.
+func delConnectionHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+}
+
+// BasicProxyAuth returns a ProxyAuthenticator accepting a single username and password.
+// Optimization: The expected header is computed once and compared in constant time.
+func BasicProxyAuth(username, password string) ProxyAuthenticator {
+	expected := []byte(BasicAuthHeaderStr(username, password))
+	return func(r *http.Request) bool {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Proxy-Authorization")), expected) == 1
+	}
+}
+
+// ProxyBasicAuth returns the username and password of a request's Basic Proxy-Authorization header.
+// Optimization: Decodes the credentials without intermediate strings.
+func ProxyBasicAuth(r *http.Request) (username, password string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if len(auth) < basicAuthPrefixLen || !strings.EqualFold(auth[:basicAuthPrefixLen], basicAuthPrefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[basicAuthPrefixLen:])
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
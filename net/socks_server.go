@@ -0,0 +1,403 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	socks5ReplySucceeded        = 0x00
+	socks5ReplyGeneralFailure   = 0x01
+	socks5ReplyNotAllowed       = 0x02
+	socks5ReplyNetUnreachable   = 0x03
+	socks5ReplyHostUnreachable  = 0x04
+	socks5ReplyConnRefused      = 0x05
+	socks5ReplyCmdNotSupported  = 0x07
+	socks5ReplyAtypNotSupported = 0x08
+
+	// DefaultSocksHandshakeTimeout bounds a client's greeting, authentication and request.
+	DefaultSocksHandshakeTimeout = 10 * time.Second
+	// socks5UDPBufSize fits any UDP datagram.
+	socks5UDPBufSize = 64 << 10
+)
+
+// SocksRule allows or denies destinations by address prefix and port range.
+// A zero Prefix matches every address and a zero MaxPort every port.
+type SocksRule struct {
+	Allow   bool
+	Prefix  netip.Prefix
+	MinPort uint16
+	MaxPort uint16
+}
+
+// matches reports whether the rule applies to the address and port.
+func (r SocksRule) matches(addr netip.Addr, port uint16) bool {
+	if r.Prefix.IsValid() && !r.Prefix.Contains(addr.Unmap()) {
+		return false
+	}
+	return r.MaxPort == 0 || (port >= r.MinPort && port <= r.MaxPort)
+}
+
+// Socks5Server is a SOCKS5 proxy server supporting CONNECT and, when AllowUDP is set,
+// UDP ASSOCIATE. TCP connections to targets go through Dialer, so it can front a ProxyPool;
+// UDP datagrams are relayed directly.
+type Socks5Server struct {
+	// Dialer reaches CONNECT targets; nil means a zero net.Dialer.
+	Dialer ContextDialer
+	// Authenticate enables username/password authentication when set; otherwise no authentication is required.
+	Authenticate func(username, password string) bool
+	// Rules are checked in order and the first match decides. Host names are resolved to check them
+	// and allowed only if all their addresses are; the checked addresses are then dialed, never the name.
+	Rules []SocksRule
+	// DenyByDefault denies destinations matching no rule.
+	DenyByDefault bool
+	// AllowUDP enables UDP ASSOCIATE.
+	AllowUDP bool
+	// HandshakeTimeout bounds each client's handshake; zero means DefaultSocksHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes CONNECT tunnels without traffic for that long; zero disables it.
+	IdleTimeout time.Duration
+	// OnError receives per-connection errors, which never stop the server.
+	OnError func(error)
+}
+
+// NewSocks5Server creates a Socks5Server reaching targets through an optional dialer.
+// Optimization: Single allocation.
+func NewSocks5Server(dialer ...ContextDialer) *Socks5Server {
+	s := &Socks5Server{}
+	if len(dialer) > 0 {
+		s.Dialer = dialer[0]
+	}
+	return s
+}
+
+// ListenAndServe serves SOCKS5 clients on addr.
+// Optimization: Delegates to Serve.
+func (s *Socks5Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine until ln fails or is closed.
+// Optimization: One goroutine per client, no per-connection allocations beyond the handshake.
+func (s *Socks5Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single client connection and closes it when done.
+// Optimization: Handshake messages are read with fixed-size buffers.
+func (s *Socks5Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultSocksHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	cmd, target, err := s.handshake(conn)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	switch cmd {
+	case socks5CmdConnect:
+		err = s.connect(conn, target, timeout)
+	case socks5CmdUDPAssociate:
+		if !s.AllowUDP {
+			socks5Reply(conn, socks5ReplyCmdNotSupported, socksAddr{})
+			return
+		}
+		err = s.associate(conn)
+	default:
+		socks5Reply(conn, socks5ReplyCmdNotSupported, socksAddr{})
+		return
+	}
+	if err != nil {
+		s.report(err)
+	}
+}
+
+// handshake negotiates authentication and reads the request.
+func (s *Socks5Server) handshake(conn net.Conn) (byte, socksAddr, error) {
+	var b [255]byte
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return 0, socksAddr{}, err
+	}
+	if b[0] != socks5Version {
+		return 0, socksAddr{}, ErrSocksProtocol
+	}
+	methods := b[:b[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, socksAddr{}, err
+	}
+	want := byte(socks5AuthNone)
+	if s.Authenticate != nil {
+		want = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return 0, socksAddr{}, ErrSocksNoAcceptableAuth
+	}
+	if _, err := conn.Write([]byte{socks5Version, want}); err != nil {
+		return 0, socksAddr{}, err
+	}
+	if want == socks5AuthPassword {
+		if err := s.authenticate(conn); err != nil {
+			return 0, socksAddr{}, err
+		}
+	}
+	if _, err := io.ReadFull(conn, b[:3]); err != nil {
+		return 0, socksAddr{}, err
+	}
+	if b[0] != socks5Version {
+		return 0, socksAddr{}, ErrSocksProtocol
+	}
+	cmd := b[1]
+	target, err := readSocksAddr(conn)
+	if errors.Is(err, ErrSocksProtocol) {
+		socks5Reply(conn, socks5ReplyAtypNotSupported, socksAddr{})
+	}
+	return cmd, target, err
+}
+
+// authenticate runs the username/password subnegotiation of RFC 1929.
+func (s *Socks5Server) authenticate(conn net.Conn) error {
+	var b [256]byte
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return err
+	}
+	if b[0] != 1 {
+		return ErrSocksProtocol
+	}
+	ulen := int(b[1])
+	if _, err := io.ReadFull(conn, b[:ulen+1]); err != nil {
+		return err
+	}
+	username := string(b[:ulen])
+	n := b[ulen]
+	if _, err := io.ReadFull(conn, b[:n]); err != nil {
+		return err
+	}
+	if !s.Authenticate(username, string(b[:n])) {
+		conn.Write([]byte{1, 1})
+		return ErrSocksAuthFailed
+	}
+	_, err := conn.Write([]byte{1, 0})
+	return err
+}
+
+// connect dials the target and tunnels the client to it.
+func (s *Socks5Server) connect(conn net.Conn, target socksAddr, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	targets, ok := s.allowed(ctx, target)
+	if !ok {
+		socks5Reply(conn, socks5ReplyNotAllowed, socksAddr{})
+		return nil
+	}
+	var out net.Conn
+	var err error
+	for _, t := range targets {
+		if out, err = dialer(s.Dialer).DialContext(ctx, "tcp", t.String()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		socks5Reply(conn, socks5ReplyCode(err), socksAddr{})
+		return err
+	}
+	if err := socks5Reply(conn, socks5ReplySucceeded, addrOf(out.LocalAddr())); err != nil {
+		out.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	Tunnel(conn, out, s.IdleTimeout)
+	return nil
+}
+
+// associate relays datagrams between the client and targets until the control connection closes.
+func (s *Socks5Server) associate(ctrl net.Conn) error {
+	local := addrOf(ctrl.LocalAddr())
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		socks5Reply(ctrl, socks5ReplyGeneralFailure, socksAddr{})
+		return err
+	}
+	defer relay.Close()
+	outbound, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		socks5Reply(ctrl, socks5ReplyGeneralFailure, socksAddr{})
+		return err
+	}
+	defer outbound.Close()
+	if err := socks5Reply(ctrl, socks5ReplySucceeded, addrOf(relay.LocalAddr())); err != nil {
+		return err
+	}
+	ctrl.SetDeadline(time.Time{})
+	clientIP := addrOf(ctrl.RemoteAddr()).IP
+	client := make(chan *net.UDPAddr, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.relayOut(ctx, relay, outbound, clientIP, client)
+	go relayIn(relay, outbound, client)
+	io.Copy(io.Discard, ctrl)
+	return nil
+}
+
+// relayOut forwards the client's datagrams to their targets.
+func (s *Socks5Server) relayOut(ctx context.Context, relay, outbound *net.UDPConn, clientIP net.IP, client chan<- *net.UDPAddr) {
+	buf := make([]byte, socks5UDPBufSize)
+	known := false
+	defer func() {
+		if !known {
+			close(client)
+		}
+	}()
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(clientIP) || n < 4 || buf[2] != 0 {
+			continue
+		}
+		if !known {
+			client <- from
+			known = true
+		}
+		r := &sliceReader{b: buf[3:n]}
+		target, err := readSocksAddr(r)
+		if err != nil {
+			continue
+		}
+		targets, ok := s.allowed(ctx, target)
+		if !ok {
+			continue
+		}
+		to, err := net.ResolveUDPAddr("udp", targets[0].String())
+		if err != nil {
+			continue
+		}
+		outbound.WriteToUDP(r.b, to)
+	}
+}
+
+// relayIn returns datagrams from targets to the client, prefixed with their source address.
+func relayIn(relay, outbound *net.UDPConn, client <-chan *net.UDPAddr) {
+	to, ok := <-client
+	if !ok {
+		return
+	}
+	buf := make([]byte, socks5UDPBufSize)
+	for {
+		n, from, err := outbound.ReadFromUDP(buf[socks5UDPHeaderMax:])
+		if err != nil {
+			return
+		}
+		header, err := socksAddr{IP: from.IP, Port: from.Port}.append([]byte{0, 0, 0})
+		if err != nil {
+			continue
+		}
+		start := socks5UDPHeaderMax - len(header)
+		copy(buf[start:], header)
+		relay.WriteToUDP(buf[start:socks5UDPHeaderMax+n], to)
+	}
+}
+
+// allowed applies the rules to the target and returns the addresses to dial. When rules exist,
+// host names are resolved once here and the vetted IPs are returned, so a second lookup at dial
+// time cannot be rebound to a denied address.
+func (s *Socks5Server) allowed(ctx context.Context, target socksAddr) ([]socksAddr, bool) {
+	if len(s.Rules) == 0 {
+		return []socksAddr{target}, !s.DenyByDefault
+	}
+	ips := []net.IP{target.IP}
+	if target.IP == nil {
+		var err error
+		if ips, err = net.DefaultResolver.LookupIP(ctx, "ip", target.Name); err != nil || len(ips) == 0 {
+			return nil, false
+		}
+	}
+	targets := make([]socksAddr, 0, len(ips))
+	for _, ip := range ips {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok || !s.allowedAddr(addr, uint16(target.Port)) {
+			return nil, false
+		}
+		targets = append(targets, socksAddr{IP: ip, Port: target.Port})
+	}
+	return targets, true
+}
+
+// allowedAddr returns the decision of the first matching rule.
+func (s *Socks5Server) allowedAddr(addr netip.Addr, port uint16) bool {
+	for _, r := range s.Rules {
+		if r.matches(addr, port) {
+			return r.Allow
+		}
+	}
+	return !s.DenyByDefault
+}
+
+// report passes an error to OnError.
+func (s *Socks5Server) report(err error) {
+	if s.OnError != nil && !IsConnClosedErr(err) {
+		s.OnError(err)
+	}
+}
+
+// socks5Reply writes a reply with the bound address, or 0.0.0.0:0 when it is empty.
+func socks5Reply(conn net.Conn, code byte, bound socksAddr) error {
+	if bound.IP == nil && bound.Name == "" {
+		bound.IP = net.IPv4zero
+	}
+	b, err := bound.append([]byte{socks5Version, code, 0})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+// socks5ReplyCode maps a dial error to a reply code.
+func socks5ReplyCode(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ReplyConnRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5ReplyNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr), errors.Is(err, context.DeadlineExceeded):
+		return socks5ReplyHostUnreachable
+	default:
+		return socks5ReplyGeneralFailure
+	}
+}
+
+// addrOf converts a TCP or UDP address to a socksAddr.
+func addrOf(addr net.Addr) socksAddr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return socksAddr{IP: a.IP, Port: a.Port}
+	case *net.UDPAddr:
+		return socksAddr{IP: a.IP, Port: a.Port}
+	}
+	return socksAddr{}
+}
@@ -0,0 +1,127 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSocks5PacketConnDropsForeignDatagrams(t *testing.T) {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl, peer := net.Pipe()
+	defer peer.Close()
+	c := &Socks5PacketConn{UDPConn: pc, ctrl: ctrl, relay: relay.LocalAddr().(*net.UDPAddr)}
+	defer c.Close()
+
+	datagram := func(payload string) []byte {
+		b, _ := socksAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}.append([]byte{0, 0, 0})
+		return append(b, payload...)
+	}
+	if _, err := stranger.WriteTo(datagram("spoofed"), pc.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.WriteTo(datagram("relayed"), pc.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 64)
+	n, from, err := c.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "relayed" || from.String() != "192.0.2.1:53" {
+		t.Fatalf("got %q from %v, want %q from 192.0.2.1:53", b[:n], from, "relayed")
+	}
+}
+
+// recordingDialer records the addresses it is asked to dial.
+type recordingDialer struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.addrs = append(d.addrs, addr)
+	d.mu.Unlock()
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, addr)
+}
+
+func TestSocks5ServerDialsVettedAddresses(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(backend.Addr().String())
+	loopback := []SocksRule{
+		{Allow: true, Prefix: netip.MustParsePrefix("127.0.0.0/8")},
+		{Allow: true, Prefix: netip.MustParsePrefix("::1/128")},
+	}
+	tests := []struct {
+		name    string
+		rules   []SocksRule
+		allowed bool
+	}{
+		{"allowed", loopback, true},
+		{"denied", []SocksRule{{Allow: false}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &recordingDialer{}
+			s := NewSocks5Server(d)
+			s.Rules, s.DenyByDefault = tt.rules, true
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go s.Serve(ln)
+
+			conn, err := NewSocks5Dialer(ln.Addr().String()).Dial("tcp", net.JoinHostPort("localhost", port))
+			if tt.allowed != (err == nil) {
+				t.Fatalf("Dial err = %v, want allowed %v", err, tt.allowed)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if tt.allowed == (len(d.addrs) == 0) {
+				t.Fatalf("dialed %v, want a dial only when allowed", d.addrs)
+			}
+			for _, addr := range d.addrs {
+				host, _, _ := net.SplitHostPort(addr)
+				if ip, err := netip.ParseAddr(host); err != nil || !ip.IsLoopback() {
+					t.Fatalf("dialed %q, want a vetted loopback address rather than the name", addr)
+				}
+			}
+		})
+	}
+}
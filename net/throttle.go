@@ -0,0 +1,290 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minBurst is the smallest burst of a RateLimiter, so tiny rates still move whole packets.
+const minBurst = 1 << 10
+
+// RateLimiter is a token bucket limiting bytes per second. One limiter may be shared by any
+// number of connections, readers and writers to cap their combined throughput.
+type RateLimiter struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec with an optional burst, which defaults
+// to one second of traffic. A non-positive rate means unlimited.
+// Optimization: The bucket starts full and is refilled lazily on use, no background goroutine.
+func NewRateLimiter(bytesPerSec int64, burst ...int) *RateLimiter {
+	l := &RateLimiter{last: time.Now()}
+	b := 0
+	if len(burst) > 0 {
+		b = burst[0]
+	}
+	l.set(bytesPerSec, b)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// SetRate changes the rate and burst, which defaults to one second of traffic; waiting callers keep their reservations.
+// Optimization: Constant time under the lock.
+func (l *RateLimiter) SetRate(bytesPerSec int64, burst ...int) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.refill(time.Now())
+	b := 0
+	if len(burst) > 0 {
+		b = burst[0]
+	}
+	l.set(bytesPerSec, b)
+	l.tokens = min(l.tokens, float64(l.burst))
+}
+
+// Burst returns the bucket size, the most bytes a single operation takes at once.
+// Optimization: Constant time under the lock.
+func (l *RateLimiter) Burst() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.burst
+}
+
+// Wait blocks until n bytes may pass or ctx is done. Requests larger than the burst are allowed
+// and paid back by later callers waiting longer.
+// Optimization: A single reservation per call; callers sleep outside the lock.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	return sleep(l.reserve(n), ctx.Done(), ctx.Err)
+}
+
+// set applies the rate and burst; the caller holds the lock.
+func (l *RateLimiter) set(bytesPerSec int64, burst int) {
+	l.rate = float64(bytesPerSec)
+	if burst <= 0 {
+		burst = int(bytesPerSec)
+	}
+	l.burst = max(burst, minBurst)
+}
+
+// refill adds the tokens accumulated since the last use; the caller holds the lock.
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// reserve takes n tokens, possibly going into debt, and returns how long to wait for them.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// chunk returns how many bytes of a len-n operation to pass at once; unlimited limiters pass all of them.
+func (l *RateLimiter) chunk(n int) int {
+	if l == nil {
+		return n
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.rate <= 0 {
+		return n
+	}
+	return min(n, l.burst)
+}
+
+// sleep waits for d unless done is closed first, then returns cause().
+func sleep(d time.Duration, done <-chan struct{}, cause func() error) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-done:
+		return cause()
+	}
+}
+
+// ByteCounter counts bytes read and written; one counter may be shared by many wrappers,
+// e.g. to bill all traffic of a customer.
+type ByteCounter struct {
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// BytesRead returns the bytes read so far.
+// Optimization: Atomic load, no locking.
+func (c *ByteCounter) BytesRead() int64 {
+	return c.read.Load()
+}
+
+// BytesWritten returns the bytes written so far.
+// Optimization: Atomic load, no locking.
+func (c *ByteCounter) BytesWritten() int64 {
+	return c.written.Load()
+}
+
+// Reset zeroes the counter, returning the bytes read and written since the last reset.
+// Optimization: Atomic swaps, so no bytes are lost between reading and resetting.
+func (c *ByteCounter) Reset() (read, written int64) {
+	return c.read.Swap(0), c.written.Swap(0)
+}
+
+// addRead counts bytes read; a nil counter ignores them.
+func (c *ByteCounter) addRead(n int) {
+	if c != nil && n > 0 {
+		c.read.Add(int64(n))
+	}
+}
+
+// addWritten counts bytes written; a nil counter ignores them.
+func (c *ByteCounter) addWritten(n int) {
+	if c != nil && n > 0 {
+		c.written.Add(int64(n))
+	}
+}
+
+// ThrottledReader limits and counts the bytes read from an io.Reader.
+type ThrottledReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+	counter *ByteCounter
+}
+
+// ThrottleReader wraps r with a limiter, which may be nil to only count, and an optional counter.
+// Optimization: Reads are capped at the burst size so no single read overdraws the bucket.
+func ThrottleReader(r io.Reader, l *RateLimiter, counter ...*ByteCounter) *ThrottledReader {
+	tr := &ThrottledReader{r: r, limiter: l}
+	if len(counter) > 0 {
+		tr.counter = counter[0]
+	}
+	return tr
+}
+
+// Read reads at most a burst of bytes and waits for the limiter to allow them.
+// Optimization: Pays for bytes after reading, so no tokens are spent on short reads.
+func (tr *ThrottledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p[:tr.limiter.chunk(len(p))])
+	tr.counter.addRead(n)
+	time.Sleep(tr.limiter.reserve(n))
+	return n, err
+}
+
+// ThrottledWriter limits and counts the bytes written to an io.Writer.
+type ThrottledWriter struct {
+	w       io.Writer
+	limiter *RateLimiter
+	counter *ByteCounter
+}
+
+// ThrottleWriter wraps w with a limiter, which may be nil to only count, and an optional counter.
+// Optimization: Writes are split into burst-sized chunks so output is paced smoothly.
+func ThrottleWriter(w io.Writer, l *RateLimiter, counter ...*ByteCounter) *ThrottledWriter {
+	tw := &ThrottledWriter{w: w, limiter: l}
+	if len(counter) > 0 {
+		tw.counter = counter[0]
+	}
+	return tw
+}
+
+// Write waits for the limiter before writing each chunk of p.
+// Optimization: Unlimited writers pass p through in a single call.
+func (tw *ThrottledWriter) Write(p []byte) (int, error) {
+	return throttledWrite(tw.w, p, tw.limiter, tw.counter, nil)
+}
+
+// throttledWrite writes p in burst-sized chunks, each after waiting for the limiter.
+func throttledWrite(w io.Writer, p []byte, l *RateLimiter, counter *ByteCounter, done <-chan struct{}) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := l.chunk(len(p))
+		if err := sleep(l.reserve(n), done, func() error { return net.ErrClosed }); err != nil {
+			return written, err
+		}
+		nw, err := w.Write(p[:n])
+		written += nw
+		counter.addWritten(nw)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ThrottledConn is a net.Conn whose reads and writes are limited and counted.
+// Closing the connection interrupts pending waits.
+type ThrottledConn struct {
+	net.Conn
+	read    *RateLimiter
+	write   *RateLimiter
+	counter *ByteCounter
+	once    sync.Once
+	done    chan struct{}
+}
+
+// ThrottleConn wraps c with read and write limiters, either of which may be nil, and an optional counter.
+// Sharing limiters between connections caps their combined bandwidth, e.g. egress per proxy.
+// Optimization: Limiters are only consulted for the directions they are set for.
+func ThrottleConn(c net.Conn, read, write *RateLimiter, counter ...*ByteCounter) *ThrottledConn {
+	tc := &ThrottledConn{Conn: c, read: read, write: write, done: make(chan struct{})}
+	if len(counter) > 0 {
+		tc.counter = counter[0]
+	}
+	return tc
+}
+
+// Read reads at most a burst of bytes and waits for the read limiter to allow them.
+// Optimization: Pays for bytes after reading, so no tokens are spent on short reads.
+func (tc *ThrottledConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p[:tc.read.chunk(len(p))])
+	tc.counter.addRead(n)
+	if werr := sleep(tc.read.reserve(n), tc.done, func() error { return net.ErrClosed }); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// Write waits for the write limiter before writing each chunk of p.
+// Optimization: Unlimited connections pass p through in a single call.
+func (tc *ThrottledConn) Write(p []byte) (int, error) {
+	return throttledWrite(tc.Conn, p, tc.write, tc.counter, tc.done)
+}
+
+// Close closes the connection and interrupts pending waits.
+// Optimization: The wait channel is closed once.
+func (tc *ThrottledConn) Close() error {
+	tc.once.Do(func() { close(tc.done) })
+	return tc.Conn.Close()
+}
+
+// CloseWrite half-closes the underlying connection when it supports it, so Tunnel can half-close.
+// Optimization: Direct passthrough.
+func (tc *ThrottledConn) CloseWrite() error {
+	if cw, ok := tc.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,119 @@
+package netutils
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrTunnelIdle = errors.New("tunnel idle timeout")
+
+// tunnelBufSize is the buffer size of copies that cannot use a kernel fast path.
+const tunnelBufSize = 32 << 10
+
+var tunnelBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, tunnelBufSize)
+		return &b
+	},
+}
+
+// TunnelStats counts the bytes a tunnel copied in each direction.
+type TunnelStats struct {
+	AToB int64
+	BToA int64
+}
+
+// closeWriter is implemented by connections supporting half-close, such as *net.TCPConn and *tls.Conn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Tunnel copies between a and b in both directions until both are done, then closes them.
+// When one side reaches EOF the other's write side is half-closed so the peer sees EOF while
+// the opposite direction keeps flowing; connections without CloseWrite are fully closed instead.
+// With an idle timeout the tunnel fails with ErrTunnelIdle once neither direction moved data for that long.
+// Optimization: Without an idle timeout, io.Copy lets *net.TCPConn use splice/sendfile via
+// ReaderFrom/WriterTo; otherwise 32KiB pooled buffers are used to re-arm deadlines per read.
+func Tunnel(a, b net.Conn, idleTimeout ...time.Duration) (TunnelStats, error) {
+	idle := time.Duration(0)
+	if len(idleTimeout) > 0 {
+		idle = idleTimeout[0]
+	}
+	var (
+		stats    TunnelStats
+		last     atomic.Int64
+		closeAll sync.Once
+	)
+	last.Store(time.Now().UnixNano())
+	shutdown := func() {
+		closeAll.Do(func() {
+			a.Close()
+			b.Close()
+		})
+	}
+	errs := make(chan error, 2)
+	run := func(dst, src net.Conn, n *int64) {
+		var err error
+		*n, err = tunnelCopy(dst, src, idle, &last)
+		if cw, ok := dst.(closeWriter); err == nil && ok {
+			if cw.CloseWrite() != nil {
+				shutdown()
+			}
+		} else {
+			shutdown()
+		}
+		errs <- err
+	}
+	go run(b, a, &stats.AToB)
+	go run(a, b, &stats.BToA)
+	err := <-errs
+	if err2 := <-errs; err == nil || IsConnClosedErr(err) {
+		err = err2
+	}
+	shutdown()
+	if IsConnClosedErr(err) {
+		err = nil
+	}
+	return stats, err
+}
+
+// tunnelCopy copies src to dst, refreshing deadlines per read when idle is set.
+func tunnelCopy(dst, src net.Conn, idle time.Duration, last *atomic.Int64) (int64, error) {
+	if idle <= 0 {
+		return io.Copy(dst, src)
+	}
+	buf := tunnelBufPool.Get().(*[]byte)
+	defer tunnelBufPool.Put(buf)
+	var written int64
+	for {
+		src.SetReadDeadline(time.Now().Add(idle))
+		nr, er := src.Read(*buf)
+		if nr > 0 {
+			last.Store(time.Now().UnixNano())
+			dst.SetWriteDeadline(time.Now().Add(idle))
+			nw, ew := dst.Write((*buf)[:nr])
+			written += int64(nw)
+			if ew != nil {
+				return written, ew
+			}
+		}
+		if er == nil {
+			continue
+		}
+		if er == io.EOF {
+			return written, nil
+		}
+		var ne net.Error
+		if errors.As(er, &ne) && ne.Timeout() {
+			if time.Since(time.Unix(0, last.Load())) < idle {
+				continue
+			}
+			return written, ErrTunnelIdle
+		}
+		return written, er
+	}
+}